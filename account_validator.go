@@ -0,0 +1,220 @@
+package wxmch_api
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+/*
+	结算账户校验
+	在ApplymentSubmit或SettlementModify之前，用银行组件查询结果校验开户银行、省市编码、联行号和银行账号
+	银行列表和城市列表在第一次使用时加载并缓存
+*/
+
+// 需要校验的结算账户字段
+type bankAccountFields struct {
+	// 错误字段的JSON Pointer前缀
+	prefix          string
+	corporate       bool
+	accountBank     string
+	bankAddressCode string
+	bankBranchID    string
+	bankName        string
+	accountNumber   string
+}
+
+type AccountInfoValidator struct {
+	capital CapitalService
+
+	mu             sync.Mutex
+	personalBanks  []BankInfo
+	corporateBanks []BankInfo
+	cities         map[int]CityInfo
+}
+
+func NewAccountInfoValidator(capital CapitalService) *AccountInfoValidator {
+	return &AccountInfoValidator{capital: capital}
+}
+
+// 校验进件的结算银行账户，查询失败时返回查询的错误，校验不通过时返回ValidationErrors
+func (v *AccountInfoValidator) Validate(ctx context.Context, info AccountInfo) error {
+	return v.check(ctx, bankAccountFields{
+		prefix:          "/account_info",
+		corporate:       info.BankAccountType == BankAccountTypeCorporate,
+		accountBank:     info.AccountBank,
+		bankAddressCode: info.BankAddressCode,
+		bankBranchID:    info.BankBranchID,
+		bankName:        info.BankName,
+		accountNumber:   info.AccountNumber,
+	})
+}
+
+// 校验修改结算账户请求
+func (v *AccountInfoValidator) ValidateSettlement(ctx context.Context, req ModifySettlementRequest) error {
+	return v.check(ctx, bankAccountFields{
+		corporate:       req.AccountType == SettlementAccountTypeBusiness,
+		accountBank:     req.AccountBank,
+		bankAddressCode: req.BankAddressCode,
+		bankBranchID:    req.BankBranchID,
+		bankName:        req.BankName,
+		accountNumber:   req.AccountNumber,
+	})
+}
+
+func (v *AccountInfoValidator) check(ctx context.Context, f bankAccountFields) error {
+	var errs ValidationErrors
+	requireString(&errs, f.prefix+"/account_bank", f.accountBank)
+	requireString(&errs, f.prefix+"/bank_address_code", f.bankAddressCode)
+	requireString(&errs, f.prefix+"/account_number", f.accountNumber)
+
+	// 开户银行
+	var matched []BankInfo
+	if f.accountBank != "" {
+		banks, err := v.banks(ctx, f.corporate)
+		if err != nil {
+			return err
+		}
+		for _, bank := range banks {
+			if bank.AccountBank == f.accountBank {
+				matched = append(matched, bank)
+			}
+		}
+		if len(matched) == 0 {
+			errs.add(f.prefix+"/account_bank", f.accountBank, "不在支持的银行列表中")
+		}
+	}
+
+	// 对私账户可以按卡号识别开户银行
+	if !f.corporate && f.accountNumber != "" && len(matched) > 0 {
+		resp, err := v.capital.SearchBanksByBankAccount(ctx, SearchBanksByBankAccountRequest{AccountNumber: f.accountNumber})
+		if err != nil {
+			return err
+		}
+		found := len(resp.Data) == 0
+		for _, bank := range resp.Data {
+			if bank.AccountBank == f.accountBank {
+				found = true
+			}
+		}
+		if !found {
+			errs.add(f.prefix+"/account_number", nil, "银行账号属于%s，与开户银行不一致", resp.Data[0].AccountBank)
+		}
+	}
+
+	// 开户银行省市编码
+	cityCode := 0
+	if f.bankAddressCode != "" {
+		cities, err := v.cityMap(ctx)
+		if err != nil {
+			return err
+		}
+		code, convErr := strconv.Atoi(f.bankAddressCode)
+		if _, ok := cities[code]; convErr != nil || !ok {
+			errs.add(f.prefix+"/bank_address_code", f.bankAddressCode, "不是有效的城市编码")
+		} else {
+			cityCode = code
+		}
+	}
+
+	// 支行
+	needBranch := false
+	for _, bank := range matched {
+		needBranch = needBranch || bank.NeedBankBranch
+	}
+	if needBranch && f.bankBranchID == "" && f.bankName == "" {
+		errs.add(f.prefix+"/bank_name", nil, "开户银行%s需要填写开户银行全称或联行号", f.accountBank)
+	}
+	if f.bankBranchID != "" && cityCode != 0 && len(matched) > 0 {
+		branch, err := v.findBranch(ctx, matched, cityCode, f.bankBranchID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case branch == nil:
+			errs.add(f.prefix+"/bank_branch_id", f.bankBranchID, "不是%s在该城市的支行联行号", f.accountBank)
+		case f.bankName != "" && f.bankName != branch.BankBranchName:
+			errs.add(f.prefix+"/bank_name", f.bankName, "与联行号不一致，应为%s", branch.BankBranchName)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// 支持个人或对公业务的银行列表
+func (v *AccountInfoValidator) banks(ctx context.Context, corporate bool) (banks []BankInfo, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// 查询失败时不缓存部分结果，下次重新查询
+	if corporate {
+		if v.corporateBanks == nil {
+			banks, err = NewCorporateBankIterator(v.capital).All(ctx)
+			if err != nil {
+				return
+			}
+			v.corporateBanks = banks
+		}
+		banks = v.corporateBanks
+		return
+	}
+	if v.personalBanks == nil {
+		banks, err = NewPersonalBankIterator(v.capital).All(ctx)
+		if err != nil {
+			return
+		}
+		v.personalBanks = banks
+	}
+	banks = v.personalBanks
+	return
+}
+
+// 所有城市，key为城市编码
+func (v *AccountInfoValidator) cityMap(ctx context.Context) (cities map[int]CityInfo, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cities != nil {
+		cities = v.cities
+		return
+	}
+	provinces, err := v.capital.ProvinceList(ctx)
+	if err != nil {
+		return
+	}
+	cities = make(map[int]CityInfo)
+	for _, province := range provinces.Data {
+		resp, listErr := v.capital.CityList(ctx, CityListRequest{ProvinceCode: province.ProvinceCode})
+		if listErr != nil {
+			err = listErr
+			return
+		}
+		for _, city := range resp.Data {
+			cities[city.CityCode] = city
+		}
+	}
+	v.cities = cities
+	return
+}
+
+// 在开户银行的所有别名下查找联行号，没有找到时返回nil
+func (v *AccountInfoValidator) findBranch(ctx context.Context, banks []BankInfo, cityCode int, bankBranchID string) (branch *BankBranchInfo, err error) {
+	for _, bank := range banks {
+		it := NewBankBranchIterator(v.capital, bank.BankAliasCode, cityCode)
+		for {
+			branch, err = it.Next(ctx)
+			if err == ErrIteratorDone {
+				branch, err = nil, nil
+				break
+			}
+			if err != nil {
+				return
+			}
+			if branch.BankBranchID == bankBranchID {
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,358 @@
+package wxmch_api
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	进件草稿
+	保存明文的SubmitApplymentRequest（落盘前用AES-256-GCM加密），
+	驳回后把AuditDetail.ParamName映射回请求字段，修改后用新的OutRequestNo重新提交，并保留每次提交的记录
+*/
+
+var ErrApplymentDraftNotFound = errors.New("进件草稿不存在")
+var ErrApplymentDraftExists = errors.New("进件草稿已存在，修改后请使用Resubmit重新提交")
+
+// 业务申请编号最长124位，草稿ID预留重新提交的后缀R1~R999
+const maxApplymentDraftIDLen = 124 - 4
+
+// 最多重新提交的次数
+const maxApplymentResubmits = 999
+
+// 进件草稿的底层存储，保存的数据已经加密
+type ApplymentDraftStore interface {
+	Put(draftID string, data []byte) (err error)
+	// 草稿不存在时返回nil
+	Get(draftID string) (data []byte, err error)
+}
+
+type memoryApplymentDraftStore struct {
+	mu     sync.RWMutex
+	drafts map[string][]byte
+}
+
+func NewMemoryApplymentDraftStore() ApplymentDraftStore {
+	return &memoryApplymentDraftStore{drafts: make(map[string][]byte)}
+}
+
+func (s *memoryApplymentDraftStore) Put(draftID string, data []byte) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drafts[draftID] = append([]byte(nil), data...)
+	return
+}
+
+func (s *memoryApplymentDraftStore) Get(draftID string) (data []byte, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if d, ok := s.drafts[draftID]; ok {
+		data = append([]byte(nil), d...)
+	}
+	return
+}
+
+// 一次提交记录
+type ApplymentAttempt struct {
+	// 业务申请编号
+	OutRequestNo string `json:"out_request_no"`
+	// 微信支付申请单号
+	ApplymentID uint `json:"applyment_id"`
+	// 提交时间
+	SubmittedAt time.Time `json:"submitted_at"`
+	// 提交失败原因
+	SubmitError string `json:"submit_error,omitempty"`
+	// 最近一次查询到的申请状态
+	State string `json:"state,omitempty"`
+	// 驳回原因详情
+	AuditDetail []ApplymentAuditDetail `json:"audit_detail,omitempty"`
+}
+
+// 进件草稿
+type ApplymentDraft struct {
+	// 草稿ID
+	DraftID string `json:"draft_id"`
+	// 当前版本的进件请求（明文）
+	Request SubmitApplymentRequest `json:"request"`
+	// 提交记录，按提交顺序排列
+	Attempts []ApplymentAttempt `json:"attempts"`
+	// 创建时间
+	CreatedAt time.Time `json:"created_at"`
+	// 更新时间
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 最近一次提交记录
+func (d *ApplymentDraft) LastAttempt() (attempt *ApplymentAttempt) {
+	if len(d.Attempts) == 0 {
+		return
+	}
+	attempt = &d.Attempts[len(d.Attempts)-1]
+	return
+}
+
+// 驳回原因对应的请求字段
+type ApplymentDraftField struct {
+	// 字段的JSON Pointer
+	Path string `json:"path"`
+	// 字段当前的值
+	Value interface{} `json:"value"`
+}
+
+// 驳回原因及对应的请求字段
+type ApplymentRejection struct {
+	ApplymentAuditDetail
+	// 匹配到的请求字段，没有匹配到时为空
+	Fields []ApplymentDraftField `json:"fields"`
+}
+
+type ApplymentDrafts struct {
+	client ApplymentService
+	store  ApplymentDraftStore
+	aead   cipher.AEAD
+	mu     sync.Mutex
+}
+
+// key为32字节的AES-256密钥，用于加密存储的草稿
+func NewApplymentDrafts(client ApplymentService, store ApplymentDraftStore, key []byte) (drafts *ApplymentDrafts, err error) {
+	if len(key) != 32 {
+		err = errors.New("草稿加密密钥必须是32字节")
+		return
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	drafts = &ApplymentDrafts{client: client, store: store, aead: aead}
+	return
+}
+
+// 加载草稿
+func (d *ApplymentDrafts) Load(draftID string) (draft *ApplymentDraft, err error) {
+	data, err := d.store.Get(draftID)
+	if err != nil {
+		return
+	}
+	if data == nil {
+		err = ErrApplymentDraftNotFound
+		return
+	}
+	nonceSize := d.aead.NonceSize()
+	if len(data) < nonceSize {
+		err = errors.New("进件草稿数据损坏")
+		return
+	}
+	plain, err := d.aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(draftID))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(plain, &draft)
+	return
+}
+
+// 加密保存草稿
+func (d *ApplymentDrafts) Save(draft *ApplymentDraft) (err error) {
+	draft.UpdatedAt = time.Now()
+	if draft.CreatedAt.IsZero() {
+		draft.CreatedAt = draft.UpdatedAt
+	}
+	plain, err := json.Marshal(draft)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, d.aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	data := d.aead.Seal(nonce, nonce, plain, []byte(draft.DraftID))
+	err = d.store.Put(draft.DraftID, data)
+	return
+}
+
+// 保存草稿并首次提交，草稿ID默认使用req.OutRequestNo
+// 草稿已存在时返回ErrApplymentDraftExists，应使用Resubmit重新提交
+func (d *ApplymentDrafts) Submit(ctx context.Context, req SubmitApplymentRequest) (draft *ApplymentDraft, resp *SubmitApplymentResp, err error) {
+	if len(req.OutRequestNo) > maxApplymentDraftIDLen {
+		err = ValidationErrors{{Field: "/out_request_no", Value: req.OutRequestNo, Issue: fmt.Sprintf("草稿的业务申请编号最长%d位，需要为重新提交预留后缀", maxApplymentDraftIDLen)}}
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// 已有草稿时覆盖会丢失提交记录，而且会用已使用过的业务申请编号再次提交
+	data, err := d.store.Get(req.OutRequestNo)
+	if err != nil {
+		return
+	}
+	if data != nil {
+		err = ErrApplymentDraftExists
+		return
+	}
+	draft = &ApplymentDraft{DraftID: req.OutRequestNo, Request: req}
+	resp, err = d.submit(ctx, draft)
+	return
+}
+
+// 修改草稿后用新的业务申请编号重新提交
+func (d *ApplymentDrafts) Resubmit(ctx context.Context, draftID string, amend func(req *SubmitApplymentRequest) error) (draft *ApplymentDraft, resp *SubmitApplymentResp, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	draft, err = d.Load(draftID)
+	if err != nil {
+		return
+	}
+	if amend != nil {
+		err = amend(&draft.Request)
+		if err != nil {
+			return
+		}
+	}
+	if len(draft.Attempts) > maxApplymentResubmits {
+		err = fmt.Errorf("草稿%s重新提交次数超过%d次", draftID, maxApplymentResubmits)
+		return
+	}
+	outRequestNo := nextOutRequestNo(draftID, len(draft.Attempts))
+	if len(outRequestNo) > 124 {
+		// 限制草稿ID长度之前保存的草稿
+		err = fmt.Errorf("草稿%s重新提交的业务申请编号超过124位", draftID)
+		return
+	}
+	draft.Request.OutRequestNo = outRequestNo
+	resp, err = d.submit(ctx, draft)
+	return
+}
+
+// 第n次重新提交的业务申请编号
+func nextOutRequestNo(draftID string, n int) string {
+	if n == 0 {
+		return draftID
+	}
+	return draftID + "R" + strconv.Itoa(n)
+}
+
+// 先保存草稿再提交，提交结果追加到提交记录
+func (d *ApplymentDrafts) submit(ctx context.Context, draft *ApplymentDraft) (resp *SubmitApplymentResp, err error) {
+	err = d.Save(draft)
+	if err != nil {
+		return
+	}
+	attempt := ApplymentAttempt{OutRequestNo: draft.Request.OutRequestNo, SubmittedAt: time.Now()}
+	resp, err = d.client.ApplymentSubmit(ctx, draft.Request)
+	if err != nil {
+		attempt.SubmitError = err.Error()
+	} else {
+		attempt.ApplymentID = resp.ApplymentID
+	}
+	draft.Attempts = append(draft.Attempts, attempt)
+	if saveErr := d.Save(draft); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return
+}
+
+// 记录申请单查询结果，一般在ApplymentTracker的回调中调用
+func (d *ApplymentDrafts) RecordQueryResult(draftID string, resp *ApplymentQueryResponse) (err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	draft, err := d.Load(draftID)
+	if err != nil {
+		return
+	}
+	for i := range draft.Attempts {
+		if draft.Attempts[i].OutRequestNo != resp.OutRequestNo {
+			continue
+		}
+		draft.Attempts[i].ApplymentID = resp.ApplymentID
+		draft.Attempts[i].State = resp.ApplymentState
+		draft.Attempts[i].AuditDetail = resp.AuditDetail
+		err = d.Save(draft)
+		return
+	}
+	err = fmt.Errorf("草稿%s中没有申请单%s", draftID, resp.OutRequestNo)
+	return
+}
+
+// 最近一次提交的驳回原因及对应的请求字段
+func (d *ApplymentDrafts) Rejections(draftID string) (rejections []ApplymentRejection, err error) {
+	draft, err := d.Load(draftID)
+	if err != nil {
+		return
+	}
+	attempt := draft.LastAttempt()
+	if attempt == nil {
+		return
+	}
+	for _, detail := range attempt.AuditDetail {
+		rejections = append(rejections, ApplymentRejection{
+			ApplymentAuditDetail: detail,
+			Fields:               FindApplymentFields(draft.Request, detail.ParamName),
+		})
+	}
+	return
+}
+
+// 按驳回原因中的参数名查找请求字段
+// 参数名可以是字段名（id_card_copy），也可以带上层路径（id_card_info.id_card_copy）
+func FindApplymentFields(req SubmitApplymentRequest, paramName string) (fields []ApplymentDraftField) {
+	target := strings.FieldsFunc(paramName, func(r rune) bool { return r == '.' || r == '/' })
+	if len(target) == 0 {
+		return
+	}
+	walkJSONFields(reflect.ValueOf(req), nil, func(path []string, v reflect.Value) {
+		if len(path) < len(target) {
+			return
+		}
+		tail := path[len(path)-len(target):]
+		for i := range target {
+			if tail[i] != target[i] {
+				return
+			}
+		}
+		fields = append(fields, ApplymentDraftField{Path: "/" + strings.Join(path, "/"), Value: v.Interface()})
+	})
+	return
+}
+
+// 遍历结构体的所有字段，path为json字段名
+func walkJSONFields(v reflect.Value, path []string, fn func(path []string, v reflect.Value)) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			walkJSONFields(v.Elem(), path, fn)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := jsonFieldName(field)
+			if name == "-" {
+				continue
+			}
+			fieldPath := append(append([]string(nil), path...), name)
+			fv := v.Field(i)
+			fn(fieldPath, fv)
+			walkJSONFields(fv, fieldPath, fn)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walkJSONFields(v.Index(i), append(append([]string(nil), path...), strconv.Itoa(i)), fn)
+		}
+	}
+}
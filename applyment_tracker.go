@@ -0,0 +1,255 @@
+package wxmch_api
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	二级商户进件状态跟踪
+	持久化进行中的申请单，按退避间隔轮询ApplymentQueryByOutRequestNo，
+	状态变化时回调，到达终态（FINISH/REJECTED/CANCELED/FROZEN）后停止跟踪
+*/
+
+const defaultApplymentPollMinInterval = 30 * time.Second
+const defaultApplymentPollMaxInterval = 30 * time.Minute
+
+// 跟踪中的申请单
+type TrackedApplyment struct {
+	// 业务申请编号
+	OutRequestNo string `json:"out_request_no"`
+	// 微信支付申请单号
+	ApplymentID uint `json:"applyment_id"`
+	// 最近一次查询到的申请状态
+	State string `json:"state"`
+	// 状态未变化的连续查询次数，用于计算退避间隔
+	Attempts int `json:"attempts"`
+	// 下一次查询时间
+	NextPollAt time.Time `json:"next_poll_at"`
+	// 最近一次状态变化时间
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 跟踪中申请单的存储
+type ApplymentTrackStore interface {
+	Save(a TrackedApplyment) (err error)
+	Delete(outRequestNo string) (err error)
+	List() (list []TrackedApplyment, err error)
+}
+
+type memoryApplymentTrackStore struct {
+	mu         sync.RWMutex
+	applyments map[string]TrackedApplyment
+}
+
+func NewMemoryApplymentTrackStore() ApplymentTrackStore {
+	return &memoryApplymentTrackStore{applyments: make(map[string]TrackedApplyment)}
+}
+
+func (s *memoryApplymentTrackStore) Save(a TrackedApplyment) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applyments[a.OutRequestNo] = a
+	return
+}
+
+func (s *memoryApplymentTrackStore) Delete(outRequestNo string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.applyments, outRequestNo)
+	return
+}
+
+func (s *memoryApplymentTrackStore) List() (list []TrackedApplyment, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, a := range s.applyments {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].NextPollAt.Before(list[j].NextPollAt)
+	})
+	return
+}
+
+// 状态变化回调，未设置的回调不会被调用
+type ApplymentCallbacks struct {
+	// 任意状态变化，from为变化前的状态
+	OnStateChange func(from string, resp *ApplymentQueryResponse)
+	// 待账户验证，汇款信息已解密
+	OnAccountNeedVerify func(outRequestNo string, validation ApplymentAccountValidation)
+	// 待签约
+	OnNeedSign func(outRequestNo string, signUrl string)
+	// 已驳回
+	OnRejected func(outRequestNo string, details []ApplymentAuditDetail)
+	// 完成，返回二级商户号
+	OnFinish func(outRequestNo string, subMchID string)
+	// 查询失败
+	OnError func(outRequestNo string, err error)
+}
+
+// 是否是申请单的终态
+func IsApplymentFinalState(state string) bool {
+	switch state {
+	case ApplymentStateFinish, ApplymentStateRejected, ApplymentStateCanceled, ApplymentStateFrozen:
+		return true
+	}
+	return false
+}
+
+type ApplymentTracker struct {
+	client    ApplymentService
+	store     ApplymentTrackStore
+	callbacks ApplymentCallbacks
+	// 最小查询间隔
+	MinInterval time.Duration
+	// 最大查询间隔
+	MaxInterval time.Duration
+}
+
+func NewApplymentTracker(client ApplymentService, store ApplymentTrackStore, callbacks ApplymentCallbacks) *ApplymentTracker {
+	return &ApplymentTracker{
+		client:      client,
+		store:       store,
+		callbacks:   callbacks,
+		MinInterval: defaultApplymentPollMinInterval,
+		MaxInterval: defaultApplymentPollMaxInterval,
+	}
+}
+
+// 开始跟踪申请单，一般在ApplymentSubmit成功后调用
+func (t *ApplymentTracker) Track(resp *SubmitApplymentResp) (err error) {
+	now := time.Now()
+	err = t.store.Save(TrackedApplyment{
+		OutRequestNo: resp.OutRequestNo,
+		ApplymentID:  resp.ApplymentID,
+		NextPollAt:   now,
+		UpdatedAt:    now,
+	})
+	return
+}
+
+// 停止跟踪申请单
+func (t *ApplymentTracker) Untrack(outRequestNo string) (err error) {
+	err = t.store.Delete(outRequestNo)
+	return
+}
+
+// 按interval定期查询到期的申请单，直到ctx结束
+func (t *ApplymentTracker) Run(ctx context.Context, interval time.Duration) (err error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err = t.PollOnce(ctx)
+		if err != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 查询一次所有到期的申请单，只在存储出错时返回错误，查询失败通过OnError回调
+func (t *ApplymentTracker) PollOnce(ctx context.Context) (err error) {
+	list, err := t.store.List()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, a := range list {
+		if a.NextPollAt.After(now) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		err = t.poll(ctx, a)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (t *ApplymentTracker) poll(ctx context.Context, a TrackedApplyment) (err error) {
+	resp, queryErr := t.client.ApplymentQueryByOutRequestNo(ctx, QueryApplymentByOutRequestNoRequest{OutRequestNo: a.OutRequestNo})
+	if queryErr != nil {
+		if t.callbacks.OnError != nil {
+			t.callbacks.OnError(a.OutRequestNo, queryErr)
+		}
+		a.Attempts++
+		a.NextPollAt = time.Now().Add(t.backoff(a.Attempts))
+		err = t.store.Save(a)
+		return
+	}
+	if resp.ApplymentState == a.State {
+		a.Attempts++
+		a.NextPollAt = time.Now().Add(t.backoff(a.Attempts))
+		err = t.store.Save(a)
+		return
+	}
+
+	from := a.State
+	a.State = resp.ApplymentState
+	a.ApplymentID = resp.ApplymentID
+	a.Attempts = 0
+	a.UpdatedAt = time.Now()
+	a.NextPollAt = a.UpdatedAt.Add(t.backoff(0))
+	t.fire(from, resp)
+	if IsApplymentFinalState(a.State) {
+		err = t.store.Delete(a.OutRequestNo)
+		return
+	}
+	err = t.store.Save(a)
+	return
+}
+
+func (t *ApplymentTracker) fire(from string, resp *ApplymentQueryResponse) {
+	cb := t.callbacks
+	if cb.OnStateChange != nil {
+		cb.OnStateChange(from, resp)
+	}
+	switch resp.ApplymentState {
+	case AccountNeedVerifyState:
+		if cb.OnAccountNeedVerify != nil {
+			cb.OnAccountNeedVerify(resp.OutRequestNo, resp.AccountValidation)
+		}
+	case ApplymentStateNeedSign:
+		if cb.OnNeedSign != nil {
+			cb.OnNeedSign(resp.OutRequestNo, resp.SignUrl)
+		}
+	case ApplymentStateRejected:
+		if cb.OnRejected != nil {
+			cb.OnRejected(resp.OutRequestNo, resp.AuditDetail)
+		}
+	case ApplymentStateFinish:
+		if cb.OnFinish != nil {
+			cb.OnFinish(resp.OutRequestNo, resp.SubMchID)
+		}
+	}
+}
+
+// 第n次未变化后的查询间隔：MinInterval*2^n，不超过MaxInterval
+func (t *ApplymentTracker) backoff(attempts int) time.Duration {
+	minInterval, maxInterval := t.MinInterval, t.MaxInterval
+	if minInterval <= 0 {
+		minInterval = defaultApplymentPollMinInterval
+	}
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+	interval := minInterval
+	for i := 0; i < attempts && interval < maxInterval; i++ {
+		interval *= 2
+	}
+	if interval > maxInterval {
+		interval = maxInterval
+	}
+	return interval
+}
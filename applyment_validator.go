@@ -0,0 +1,239 @@
+package wxmch_api
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+	二级商户进件提交前校验
+	按主体类型校验营业执照、组织机构代码证、经营者证件、结算账户，一次返回所有问题
+*/
+
+// 主体类型
+// 小微商户
+const OrganizationTypeMicro = "2401"
+
+// 个人卖家
+const OrganizationTypePersonalSeller = "2500"
+
+// 个体工商户
+const OrganizationTypeIndividual = "4"
+
+// 企业
+const OrganizationTypeEnterprise = "2"
+
+// 党政、机关及事业单位
+const OrganizationTypeGovernment = "3"
+
+// 其他组织
+const OrganizationTypeOther = "1708"
+
+// 经营者/法人证件类型
+const IDDocTypeMainlandIDCard = "IDENTIFICATION_TYPE_MAINLAND_IDCARD"
+const IDDocTypeOverseaPassport = "IDENTIFICATION_TYPE_OVERSEA_PASSPORT"
+const IDDocTypeHongKong = "IDENTIFICATION_TYPE_HONGKONG"
+const IDDocTypeMacao = "IDENTIFICATION_TYPE_MACAO"
+const IDDocTypeTaiwan = "IDENTIFICATION_TYPE_TAIWAN"
+
+// 进件结算账户类型
+// 对公账户
+const BankAccountTypeCorporate = "74"
+
+// 对私账户
+const BankAccountTypePersonal = "75"
+
+// 判断media_id是否由MediaUpload上传得到
+type MediaIDChecker interface {
+	IsUploaded(mediaID string) bool
+}
+
+// 记录MediaUpload返回的media_id
+type MediaIDSet struct {
+	mu  sync.RWMutex
+	ids map[string]struct{}
+}
+
+func NewMediaIDSet(mediaIDs ...string) *MediaIDSet {
+	s := &MediaIDSet{ids: make(map[string]struct{})}
+	for _, id := range mediaIDs {
+		s.Add(id)
+	}
+	return s
+}
+
+func (s *MediaIDSet) Add(mediaID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids[mediaID] = struct{}{}
+}
+
+func (s *MediaIDSet) IsUploaded(mediaID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.ids[mediaID]
+	return ok
+}
+
+// 进件校验器
+type ApplymentValidator struct {
+	// 为nil时只校验media_id的格式
+	MediaIDs MediaIDChecker
+}
+
+// 校验进件请求，包括struct tag规则和按主体类型的规则
+func (v ApplymentValidator) Validate(req SubmitApplymentRequest) error {
+	var errs ValidationErrors
+	validateValue(reflect.ValueOf(req), "", &errs)
+	errs = append(errs, applymentRules(req, v.MediaIDs)...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// 校验进件请求
+func ValidateApplyment(req SubmitApplymentRequest) error {
+	return ApplymentValidator{}.Validate(req)
+}
+
+func (r SubmitApplymentRequest) validate() ValidationErrors {
+	return applymentRules(r, nil)
+}
+
+func isMicroOrganization(organizationType string) bool {
+	return organizationType == OrganizationTypeMicro || organizationType == OrganizationTypePersonalSeller
+}
+
+func applymentRules(r SubmitApplymentRequest, mediaIDs MediaIDChecker) (errs ValidationErrors) {
+	switch r.OrganizationType {
+	case "":
+		// 由required规则报告
+	case OrganizationTypeMicro, OrganizationTypePersonalSeller:
+		if r.BusinessLicenseInfo != nil {
+			errs.add("/business_license_info", nil, "小微商户/个人卖家不需要填写营业执照信息")
+		}
+		if r.OrganizationCertInfo != nil {
+			errs.add("/organization_cert_info", nil, "小微商户/个人卖家不需要填写组织机构代码证信息")
+		}
+		if r.IDDocType != "" && r.IDDocType != IDDocTypeMainlandIDCard {
+			errs.add("/id_doc_type", r.IDDocType, "小微商户/个人卖家只能使用中国大陆居民身份证")
+		}
+	case OrganizationTypeIndividual, OrganizationTypeEnterprise, OrganizationTypeGovernment, OrganizationTypeOther:
+		if r.BusinessLicenseInfo == nil {
+			errs.add("/business_license_info", nil, "主体类型为%s时营业执照/登记证书信息不能为空", r.OrganizationType)
+		}
+		// 非三证合一（15位注册号）的企业和组织需要组织机构代码证
+		if r.OrganizationType != OrganizationTypeIndividual && r.OrganizationCertInfo == nil &&
+			r.BusinessLicenseInfo != nil && len(r.BusinessLicenseInfo.BusinessLicenseNumber) == 15 {
+			errs.add("/organization_cert_info", nil, "非三证合一的主体组织机构代码证信息不能为空")
+		}
+	default:
+		errs.add("/organization_type", r.OrganizationType, "不支持的主体类型")
+	}
+
+	if info := r.BusinessLicenseInfo; info != nil {
+		requireString(&errs, "/business_license_info/business_license_number", info.BusinessLicenseNumber)
+		requireString(&errs, "/business_license_info/merchant_name", info.MerchantName)
+		requireString(&errs, "/business_license_info/legal_person", info.LegalPerson)
+		if n := len(info.BusinessLicenseNumber); n != 0 && n != 15 && n != 18 {
+			errs.add("/business_license_info/business_license_number", info.BusinessLicenseNumber, "注册号应为15位或统一社会信用代码18位")
+		}
+		checkMediaID(&errs, mediaIDs, "/business_license_info/business_license_copy", info.BusinessLicenseCopy, true)
+	}
+	if info := r.OrganizationCertInfo; info != nil {
+		requireString(&errs, "/organization_cert_info/organization_number", info.OrganizationNumber)
+		requireString(&errs, "/organization_cert_info/organization_time", info.OrganizationTime)
+		checkMediaID(&errs, mediaIDs, "/organization_cert_info/organization_copy", info.OrganizationCopy, true)
+	}
+
+	// 经营者/法人证件
+	switch r.IDDocType {
+	case "", IDDocTypeMainlandIDCard:
+		if r.IDCardInfo == nil {
+			errs.add("/id_card_info", nil, "证件类型为身份证时身份证信息不能为空")
+			break
+		}
+		requireString(&errs, "/id_card_info/id_card_name", r.IDCardInfo.IDCardName)
+		requireString(&errs, "/id_card_info/id_card_number", r.IDCardInfo.IDCardNumber)
+		requireString(&errs, "/id_card_info/id_card_valid_time", r.IDCardInfo.IDCardValidTime)
+		checkMediaID(&errs, mediaIDs, "/id_card_info/id_card_copy", r.IDCardInfo.IDCardCopy, true)
+		checkMediaID(&errs, mediaIDs, "/id_card_info/id_card_national", r.IDCardInfo.IDCardNational, true)
+	case IDDocTypeOverseaPassport, IDDocTypeHongKong, IDDocTypeMacao, IDDocTypeTaiwan:
+		if isMicroOrganization(r.OrganizationType) {
+			break
+		}
+		if r.IDDocInfo == nil {
+			errs.add("/id_doc_info", nil, "证件类型为%s时其他类型证件信息不能为空", r.IDDocType)
+			break
+		}
+		requireString(&errs, "/id_doc_info/id_doc_name", r.IDDocInfo.IDDocName)
+		requireString(&errs, "/id_doc_info/id_doc_number", r.IDDocInfo.IDDocNumber)
+		requireString(&errs, "/id_doc_info/doc_period_end", r.IDDocInfo.DocPeriodEnd)
+		checkMediaID(&errs, mediaIDs, "/id_doc_info/id_doc_copy", r.IDDocInfo.IDDocCopy, true)
+	default:
+		errs.add("/id_doc_type", r.IDDocType, "不支持的证件类型")
+	}
+
+	// 结算账户
+	if r.NeedAccountInfo {
+		if r.AccountInfo == nil {
+			errs.add("/account_info", nil, "need_account_info为true时结算银行账户不能为空")
+		} else {
+			info := r.AccountInfo
+			requireString(&errs, "/account_info/bank_account_type", info.BankAccountType)
+			requireString(&errs, "/account_info/account_bank", info.AccountBank)
+			requireString(&errs, "/account_info/account_name", info.AccountName)
+			requireString(&errs, "/account_info/bank_address_code", info.BankAddressCode)
+			requireString(&errs, "/account_info/account_number", info.AccountNumber)
+			switch {
+			case info.BankAccountType == "":
+			case info.BankAccountType != BankAccountTypeCorporate && info.BankAccountType != BankAccountTypePersonal:
+				errs.add("/account_info/bank_account_type", info.BankAccountType, "账户类型应为74(对公)或75(对私)")
+			case isMicroOrganization(r.OrganizationType) && info.BankAccountType != BankAccountTypePersonal:
+				errs.add("/account_info/bank_account_type", info.BankAccountType, "小微商户/个人卖家只能使用对私账户")
+			case (r.OrganizationType == OrganizationTypeEnterprise || r.OrganizationType == OrganizationTypeGovernment || r.OrganizationType == OrganizationTypeOther) &&
+				info.BankAccountType != BankAccountTypeCorporate:
+				errs.add("/account_info/bank_account_type", info.BankAccountType, "企业和组织只能使用对公账户")
+			}
+		}
+	} else if r.AccountInfo != nil {
+		errs.add("/need_account_info", r.NeedAccountInfo, "填写了结算银行账户时need_account_info应为true")
+	}
+
+	checkMediaID(&errs, mediaIDs, "/sales_scene_info/store_qr_code", r.SalesSceneInfo.StoreQrCode, false)
+	for i, id := range r.Qualifications {
+		checkMediaID(&errs, mediaIDs, fmt.Sprintf("/qualifications/%d", i), id, true)
+	}
+	for i, id := range r.BusinessAdditionPics {
+		checkMediaID(&errs, mediaIDs, fmt.Sprintf("/business_addition_pics/%d", i), id, true)
+	}
+	return
+}
+
+func requireString(errs *ValidationErrors, field string, value string) {
+	if value == "" {
+		errs.add(field, value, "不能为空")
+	}
+}
+
+// media_id必须是MediaUpload返回的值，而不是图片地址或文件名
+func checkMediaID(errs *ValidationErrors, mediaIDs MediaIDChecker, field string, mediaID string, required bool) {
+	if mediaID == "" {
+		if required {
+			errs.add(field, mediaID, "不能为空")
+		}
+		return
+	}
+	lower := strings.ToLower(mediaID)
+	if strings.Contains(lower, "://") || strings.ContainsAny(lower, " \t") ||
+		strings.HasSuffix(lower, ".jpg") || strings.HasSuffix(lower, ".jpeg") || strings.HasSuffix(lower, ".png") || strings.HasSuffix(lower, ".bmp") {
+		errs.add(field, mediaID, "应为MediaUpload返回的media_id")
+		return
+	}
+	if mediaIDs != nil && !mediaIDs.IsUploaded(mediaID) {
+		errs.add(field, mediaID, "media_id不是通过MediaUpload上传得到的")
+	}
+}
@@ -0,0 +1,165 @@
+package wxmch_api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	账单下载
+	申请账单接口返回下载地址和原始账单的摘要，下载时边读边计算摘要，读到文件末尾时校验，
+	压缩账单（tar_type=GZIP）解压后计算摘要。下载接口的应答没有签名，只能通过摘要校验完整性
+*/
+
+// 账单压缩类型
+const BillTarTypeGzip = "GZIP"
+
+var ErrBillHashMismatch = errors.New("账单摘要校验失败")
+
+// 申请账单的结果
+type BillDownloadInfo struct {
+	// 原始账单（gzip需要解压缩）的摘要算法，SHA1
+	HashType string `json:"hash_type"`
+	// 原始账单（gzip需要解压缩）的摘要值
+	HashValue string `json:"hash_value"`
+	// 账单下载地址
+	DownloadUrl string `json:"download_url"`
+	// 账单压缩类型，与申请账单时的tar_type一致，为空时不压缩
+	TarType string `json:"-"`
+}
+
+// 读到文件末尾时校验摘要
+type billReader struct {
+	body   io.ReadCloser
+	reader io.Reader
+	hash   hash.Hash
+	want   string
+}
+
+func (r *billReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && !strings.EqualFold(hex.EncodeToString(r.hash.Sum(nil)), r.want) {
+		err = ErrBillHashMismatch
+	}
+	return
+}
+
+func (r *billReader) Close() error {
+	return r.body.Close()
+}
+
+// 按摘要算法创建hash
+func newBillHash(hashType string) (h hash.Hash, err error) {
+	switch strings.ToUpper(hashType) {
+	case "SHA1":
+		h = sha1.New()
+	case "SHA256":
+		h = sha256.New()
+	default:
+		err = fmt.Errorf("不支持的账单摘要算法:%s", hashType)
+	}
+	return
+}
+
+// 下载账单，返回解压后的账单内容，读到文件末尾时摘要不一致返回ErrBillHashMismatch
+func (c BaseClient) DownloadBill(ctx context.Context, info BillDownloadInfo) (body io.ReadCloser, err error) {
+	h, err := newBillHash(info.HashType)
+	if err != nil {
+		return
+	}
+	u, err := url.Parse(info.DownloadUrl)
+	if err != nil {
+		return
+	}
+	nonce := RandStringBytesMaskImprSrc(10)
+	ts := int(time.Now().Unix())
+	// 签名使用下载地址的路径和参数
+	signature, err := CreateSignature("GET", u.RequestURI(), ts, nonce, nil, c.signer)
+	if err != nil {
+		err = fmt.Errorf("请求签名失败:%v", err)
+		return
+	}
+	req, _ := http.NewRequestWithContext(ctx, "GET", info.DownloadUrl, nil)
+	req.Header.Set("Authorization", c.formatAuthorizationHeader(nonce, ts, signature))
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	// 下载文件可能较大，只使用ctx控制超时
+	rawResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	if rawResp.StatusCode != http.StatusOK {
+		res, _ := ioutil.ReadAll(rawResp.Body)
+		rawResp.Body.Close()
+		err = buildErrorIfExist(rawResp.StatusCode, res)
+		if err == nil {
+			err = fmt.Errorf("下载账单失败:%s", rawResp.Status)
+		}
+		return
+	}
+	var reader io.Reader = rawResp.Body
+	if strings.EqualFold(info.TarType, BillTarTypeGzip) {
+		reader, err = gzip.NewReader(rawResp.Body)
+		if err != nil {
+			rawResp.Body.Close()
+			return
+		}
+	}
+	body = &billReader{body: rawResp.Body, reader: reader, hash: h, want: info.HashValue}
+	return
+}
+
+// 账单中的字段以`开头，避免被表格软件转换格式
+func trimBillField(field string) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(field), "`"))
+}
+
+// 账单中以元为单位的金额转换为分
+func parseBillYuan(s string) (fen int64, err error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	parts := strings.SplitN(s, ".", 2)
+	yuan, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return
+	}
+	fen = yuan * 100
+	if len(parts) == 2 {
+		decimal := (parts[1] + "00")[:2]
+		var cents int64
+		cents, err = strconv.ParseInt(decimal, 10, 64)
+		if err != nil {
+			return
+		}
+		fen += cents
+	}
+	if negative {
+		fen = -fen
+	}
+	return
+}
+
+// 账单中的时间，北京时间
+func parseBillTime(s string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04:05", s, time.FixedZone("CST", 8*3600))
+}
+
+// 去掉UTF-8 BOM
+func trimBOM(line []byte) []byte {
+	return bytes.TrimPrefix(line, []byte("\xef\xbb\xbf"))
+}
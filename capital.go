@@ -0,0 +1,340 @@
+package wxmch_api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+/*
+	银行组件
+	查询开户银行、省市、支行，用于填写进件和修改结算账户时的开户银行、开户银行省市编码、开户银行联行号
+*/
+
+// 银行列表每页最大条数
+const MaxCapitalPageLimit = 200
+
+// 银行信息
+type BankInfo struct {
+	// 银行别名
+	BankAlias string `json:"bank_alias"`
+	// 银行别名编码
+	BankAliasCode string `json:"bank_alias_code"`
+	// 开户银行
+	AccountBank string `json:"account_bank"`
+	// 开户银行编码
+	AccountBankCode int `json:"account_bank_code"`
+	// 是否需要填写支行
+	NeedBankBranch bool `json:"need_bank_branch"`
+}
+
+type SearchBanksByBankAccountRequest struct {
+	// 银行账号（明文，请求时会加密）
+	AccountNumber string `validate:"required"`
+}
+
+type SearchBanksByBankAccountResponse struct {
+	// 查询数据总条数
+	TotalCount int `json:"total_count"`
+	// 银行列表
+	Data []BankInfo `json:"data"`
+}
+
+// 获取对私银行卡号开户银行
+func (c MerchantApiClient) SearchBanksByBankAccount(ctx context.Context, req SearchBanksByBankAccountRequest) (resp *SearchBanksByBankAccountResponse, err error) {
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	qm := map[string]string{"account_number": encryptCiphertext(req.AccountNumber, c.getPlatformPublicKey())}
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", "/v3/capital/capitallhh/banks/search-banks-by-bank-account", qm, nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	return
+}
+
+type BankListRequest struct {
+	// 本次查询偏移量
+	Offset int
+	// 本次请求最大查询条数，最大值为200
+	Limit int `validate:"required,min=1,max=200"`
+}
+
+type BankListResponse struct {
+	// 查询数据总条数
+	TotalCount int `json:"total_count"`
+	// 本次查询数据条数
+	Count int `json:"count"`
+	// 银行列表
+	Data []BankInfo `json:"data"`
+	// 本次查询偏移量
+	Offset int `json:"offset"`
+}
+
+// 查询支持个人业务的银行列表
+func (c MerchantApiClient) PersonalBankingList(ctx context.Context, req BankListRequest) (resp *BankListResponse, err error) {
+	resp, err = c.bankList(ctx, "/v3/capital/capitallhh/banks/personal-banking", req)
+	return
+}
+
+// 查询支持对公业务的银行列表
+func (c MerchantApiClient) CorporateBankingList(ctx context.Context, req BankListRequest) (resp *BankListResponse, err error) {
+	resp, err = c.bankList(ctx, "/v3/capital/capitallhh/banks/corporate-banking", req)
+	return
+}
+
+func (c MerchantApiClient) bankList(ctx context.Context, url string, req BankListRequest) (resp *BankListResponse, err error) {
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	qm := map[string]string{
+		"offset": strconv.Itoa(req.Offset),
+		"limit":  strconv.Itoa(req.Limit),
+	}
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", url, qm, nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	return
+}
+
+// 省份信息
+type ProvinceInfo struct {
+	// 省份名称
+	ProvinceName string `json:"province_name"`
+	// 省份编码
+	ProvinceCode int `json:"province_code"`
+}
+
+type ProvinceListResponse struct {
+	// 查询数据总条数
+	TotalCount int `json:"total_count"`
+	// 省份列表
+	Data []ProvinceInfo `json:"data"`
+}
+
+// 查询省份列表
+func (c MerchantApiClient) ProvinceList(ctx context.Context) (resp *ProvinceListResponse, err error) {
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", "/v3/capital/capitallhh/areas/provinces", nil, nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	return
+}
+
+type CityListRequest struct {
+	// 省份编码
+	ProvinceCode int `validate:"required"`
+}
+
+// 城市信息
+type CityInfo struct {
+	// 城市名称
+	CityName string `json:"city_name"`
+	// 城市编码
+	CityCode int `json:"city_code"`
+}
+
+type CityListResponse struct {
+	// 查询数据总条数
+	TotalCount int `json:"total_count"`
+	// 城市列表
+	Data []CityInfo `json:"data"`
+}
+
+// 查询城市列表
+func (c MerchantApiClient) CityList(ctx context.Context, req CityListRequest) (resp *CityListResponse, err error) {
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	url := fmt.Sprintf("/v3/capital/capitallhh/areas/provinces/%d/cities", req.ProvinceCode)
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", url, nil, nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	return
+}
+
+type BankBranchListRequest struct {
+	// 银行别名编码
+	BankAliasCode string `validate:"required"`
+	// 城市编码
+	CityCode int `validate:"required"`
+	// 本次查询偏移量
+	Offset int
+	// 本次请求最大查询条数，最大值为200
+	Limit int `validate:"required,min=1,max=200"`
+}
+
+// 支行信息
+type BankBranchInfo struct {
+	// 开户银行支行名称
+	BankBranchName string `json:"bank_branch_name"`
+	// 开户银行支行联行号
+	BankBranchID string `json:"bank_branch_id"`
+}
+
+type BankBranchListResponse struct {
+	// 查询数据总条数
+	TotalCount int `json:"total_count"`
+	// 本次查询数据条数
+	Count int `json:"count"`
+	// 支行列表
+	Data []BankBranchInfo `json:"data"`
+	// 本次查询偏移量
+	Offset int `json:"offset"`
+	// 开户银行
+	AccountBank string `json:"account_bank"`
+	// 开户银行编码
+	AccountBankCode int `json:"account_bank_code"`
+	// 银行别名
+	BankAlias string `json:"bank_alias"`
+	// 银行别名编码
+	BankAliasCode string `json:"bank_alias_code"`
+}
+
+// 查询支行列表
+func (c MerchantApiClient) BankBranchList(ctx context.Context, req BankBranchListRequest) (resp *BankBranchListResponse, err error) {
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	url := fmt.Sprintf("/v3/capital/capitallhh/banks/%s/branches", req.BankAliasCode)
+	qm := map[string]string{
+		"city_code": strconv.Itoa(req.CityCode),
+		"offset":    strconv.Itoa(req.Offset),
+		"limit":     strconv.Itoa(req.Limit),
+	}
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", url, qm, nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	return
+}
+
+// 银行列表迭代器，自动翻页
+type BankIterator struct {
+	pager offsetPager
+	buf   []BankInfo
+}
+
+// 遍历支持个人业务的银行
+func NewPersonalBankIterator(s CapitalService) *BankIterator {
+	return newBankIterator(s.PersonalBankingList)
+}
+
+// 遍历支持对公业务的银行
+func NewCorporateBankIterator(s CapitalService) *BankIterator {
+	return newBankIterator(s.CorporateBankingList)
+}
+
+func newBankIterator(list func(ctx context.Context, req BankListRequest) (*BankListResponse, error)) *BankIterator {
+	it := &BankIterator{}
+	it.pager = offsetPager{
+		limit: MaxCapitalPageLimit,
+		fetch: func(ctx context.Context, offset int, limit int) (n int, total int, err error) {
+			resp, err := list(ctx, BankListRequest{Offset: offset, Limit: limit})
+			if err != nil {
+				return
+			}
+			it.buf = append(it.buf, resp.Data...)
+			n, total = len(resp.Data), resp.TotalCount
+			return
+		},
+	}
+	return it
+}
+
+// 下一个银行，遍历结束时返回ErrIteratorDone
+func (it *BankIterator) Next(ctx context.Context) (bank *BankInfo, err error) {
+	for len(it.buf) == 0 {
+		err = it.pager.nextPage(ctx)
+		if err != nil {
+			return
+		}
+	}
+	bank = &it.buf[0]
+	it.buf = it.buf[1:]
+	return
+}
+
+// 剩余的全部银行
+func (it *BankIterator) All(ctx context.Context) (banks []BankInfo, err error) {
+	for {
+		bank, nextErr := it.Next(ctx)
+		if nextErr == ErrIteratorDone {
+			return
+		}
+		if nextErr != nil {
+			err = nextErr
+			return
+		}
+		banks = append(banks, *bank)
+	}
+}
+
+// 支行列表迭代器，自动翻页
+type BankBranchIterator struct {
+	pager offsetPager
+	buf   []BankBranchInfo
+}
+
+// 遍历银行在城市中的支行
+func NewBankBranchIterator(s CapitalService, bankAliasCode string, cityCode int) *BankBranchIterator {
+	it := &BankBranchIterator{}
+	it.pager = offsetPager{
+		limit: MaxCapitalPageLimit,
+		fetch: func(ctx context.Context, offset int, limit int) (n int, total int, err error) {
+			resp, err := s.BankBranchList(ctx, BankBranchListRequest{
+				BankAliasCode: bankAliasCode,
+				CityCode:      cityCode,
+				Offset:        offset,
+				Limit:         limit,
+			})
+			if err != nil {
+				return
+			}
+			it.buf = append(it.buf, resp.Data...)
+			n, total = len(resp.Data), resp.TotalCount
+			return
+		},
+	}
+	return it
+}
+
+// 下一个支行，遍历结束时返回ErrIteratorDone
+func (it *BankBranchIterator) Next(ctx context.Context) (branch *BankBranchInfo, err error) {
+	for len(it.buf) == 0 {
+		err = it.pager.nextPage(ctx)
+		if err != nil {
+			return
+		}
+	}
+	branch = &it.buf[0]
+	it.buf = it.buf[1:]
+	return
+}
+
+// 剩余的全部支行
+func (it *BankBranchIterator) All(ctx context.Context) (branches []BankBranchInfo, err error) {
+	for {
+		branch, nextErr := it.Next(ctx)
+		if nextErr == ErrIteratorDone {
+			return
+		}
+		if nextErr != nil {
+			err = nextErr
+			return
+		}
+		branches = append(branches, *branch)
+	}
+}
@@ -33,7 +33,12 @@ func (c BaseClient) GetCertificates() (resp *GetCertificatesResp, err error) {
 	for i := range resp.Data {
 		cert := resp.Data[i]
 		encrypted := cert.EncryptCertificate
-		certContent := decryptCiphertextWithGCM(encrypted.AssociatedData, encrypted.Nonce, encrypted.Ciphertext, c.apiSecret)
+		certContent, e := decryptCiphertextWithGCM(encrypted.AssociatedData, encrypted.Nonce, encrypted.Ciphertext, c.apiSecret)
+		if e != nil {
+			err = e
+			resp = nil
+			return
+		}
 		resp.Data[i].CertContent = string(certContent)
 	}
 	return
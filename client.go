@@ -21,6 +21,10 @@ type MerchantApiClient struct {
 	platformCertMap PlatformCertificatesMap
 	// 平台证书编号（最新的）
 	platformSerialNo string
+	// 应答和回调通知的防重放校验
+	replayGuard *replayGuard
+	// 二级商户目录
+	subMerchants *SubMerchantDirectory
 	BaseClient
 }
 
@@ -29,14 +33,12 @@ type BaseClient struct {
 	mchId string
 	// 商户api证书序列号
 	certSerialNo string
-	// apiCert 商户api证书私钥明文
-	apiCert string
 	// baseUrl
 	baseUrl string
 	// timeout 调用微信支付接口超时时间
 	timeout time.Duration
-	//api rsa private key 商户api证书私钥
-	apiPriKey *rsa.PrivateKey
+	// 商户api证书私钥签名器，默认是本地的*rsa.PrivateKey
+	signer MerchantSigner
 	// api secret
 	apiSecret string
 }
@@ -45,23 +47,28 @@ const maxTimeout = 30 * time.Second
 const minTimeout = 1 * time.Second
 
 func NewBaseClient(mchID string, certSerialNo string, apiCert string, baseUrl string, timeout time.Duration, apiSecret string) (client BaseClient) {
+	signer, err := NewLocalSigner(apiCert)
+	if err != nil {
+		panic("错误的商户证书")
+	}
+	client = NewBaseClientWithSigner(mchID, certSerialNo, signer, baseUrl, timeout, apiSecret)
+	return
+}
+
+// 使用自定义签名器创建客户端，私钥不需要出现在进程内存中
+func NewBaseClientWithSigner(mchID string, certSerialNo string, signer MerchantSigner, baseUrl string, timeout time.Duration, apiSecret string) (client BaseClient) {
 	if timeout > maxTimeout {
 		timeout = maxTimeout
 	}
 	if timeout < minTimeout {
 		timeout = minTimeout
 	}
-	apiPriKey, err := buildRSAPrivateKey(apiCert)
-	if err != nil {
-		panic("错误的商户证书")
-	}
 	client = BaseClient{
 		mchId:        mchID,
 		certSerialNo: certSerialNo,
-		apiCert:      apiCert,
 		baseUrl:      baseUrl,
 		timeout:      timeout,
-		apiPriKey:    apiPriKey,
+		signer:       signer,
 		apiSecret:    apiSecret,
 	}
 	return
@@ -73,10 +80,28 @@ func NewMerchantApiClient(mchID string, certSerialNo string, apiCert string, bas
 		platformCertMap:  certMap,
 		platformSerialNo: platformNo,
 		BaseClient:       baseClient,
+		replayGuard:      newReplayGuard(DefaultMaxClockSkew, nil),
 	}
 	return
 }
 
+// 使用自定义签名器创建微信支付服务商客户端
+func NewMerchantApiClientWithSigner(mchID string, certSerialNo string, signer MerchantSigner, baseUrl string, timeout time.Duration, certMap PlatformCertificatesMap, platformNo string, apiSecret string) (client MerchantApiClient) {
+	baseClient := NewBaseClientWithSigner(mchID, certSerialNo, signer, baseUrl, timeout, apiSecret)
+	client = MerchantApiClient{
+		platformCertMap:  certMap,
+		platformSerialNo: platformNo,
+		BaseClient:       baseClient,
+		replayGuard:      newReplayGuard(DefaultMaxClockSkew, nil),
+	}
+	return
+}
+
+// 商户号
+func (c BaseClient) MchID() string {
+	return c.mchId
+}
+
 const AUTHTYPE = "WECHATPAY2-SHA256-RSA2048"
 const BOUNDARY = "boundary"
 
@@ -112,7 +137,11 @@ func (c MerchantApiClient) doRequestWithWxSerial(ctx context.Context, method str
 		requestUrl = rUrl + fmt.Sprintf("?%s", query)
 	}
 	// 验签需要带上query string
-	signature, _ := CreateSignature(method, requestUrl, ts, nonce, body, c.apiPriKey)
+	signature, err := CreateSignature(method, requestUrl, ts, nonce, body, c.signer)
+	if err != nil {
+		err = fmt.Errorf("请求签名失败:%v", err)
+		return
+	}
 	requestUrl = c.baseUrl + requestUrl
 	h := &http.Client{Timeout: c.timeout}
 	req, _ := http.NewRequestWithContext(ctx, method, requestUrl, bytes.NewBuffer(body))
@@ -129,7 +158,11 @@ func (c MerchantApiClient) doRequestWithWxSerial(ctx context.Context, method str
 func (c BaseClient) doRequestWithOutWxSerial(ctx context.Context, method string, rUrl string, qm map[string]string, body []byte) (resp *http.Response, err error) {
 	nonce := RandStringBytesMaskImprSrc(10)
 	ts := int(time.Now().Unix())
-	signature, _ := CreateSignature(method, rUrl, ts, nonce, body, c.apiPriKey)
+	signature, err := CreateSignature(method, rUrl, ts, nonce, body, c.signer)
+	if err != nil {
+		err = fmt.Errorf("请求签名失败:%v", err)
+		return
+	}
 
 	h := &http.Client{Timeout: c.timeout}
 	var requestUrl string
@@ -188,11 +221,40 @@ func (c MerchantApiClient) doRequestAndVerifySignature(ctx context.Context, meth
 		return
 	}
 	// 验证resp签名
-	wechatSignature := rawResp.Header.Get("Wechatpay-Signature")
-	wechatNonce := rawResp.Header.Get("Wechatpay-Nonce")
-	timestamp := rawResp.Header.Get("Wechatpay-Timestamp")
-	wechatSerial := rawResp.Header.Get("Wechatpay-Serial")
-	if !VerifyWechatSignature(timestamp, wechatNonce, resp, wechatSignature, c.platformCertMap.GetPublicKey(wechatSerial)) {
+	err = c.verifyWechatSignature(rawResp.Header, resp)
+	if err != nil {
+		resp = nil
+		return
+	}
+	return
+}
+
+// 验证微信支付签名，Wechatpay-Serial可以是平台证书序列号，也可以是微信支付公钥ID
+func (c MerchantApiClient) verifyWechatSignature(header http.Header, body []byte) (err error) {
+	err = c.verifyWechatSignatureWithoutNonce(header, body)
+	if err != nil {
+		return
+	}
+	err = c.replayGuard.checkNonce(header.Get("Wechatpay-Nonce"))
+	return
+}
+
+// 验证微信支付签名和时间戳，不记录nonce
+func (c MerchantApiClient) verifyWechatSignatureWithoutNonce(header http.Header, body []byte) (err error) {
+	wechatSignature := header.Get("Wechatpay-Signature")
+	wechatNonce := header.Get("Wechatpay-Nonce")
+	timestamp := header.Get("Wechatpay-Timestamp")
+	wechatSerial := header.Get("Wechatpay-Serial")
+	err = c.replayGuard.checkTimestamp(timestamp)
+	if err != nil {
+		return
+	}
+	pubKey := c.platformCertMap.GetPublicKey(wechatSerial)
+	if pubKey == nil {
+		err = fmt.Errorf("未找到平台证书或微信支付公钥:%s", wechatSerial)
+		return
+	}
+	if !VerifyWechatSignature(timestamp, wechatNonce, body, wechatSignature, pubKey) {
 		err = errors.New("resp签名错误")
 		return
 	}
@@ -227,7 +289,11 @@ func (c MerchantApiClient) doFormUpload(ctx context.Context, url string, fBytes
 		Sha256:   hex.EncodeToString(hash[:]),
 	}
 	metaStr, _ := json.Marshal(meta)
-	signature, _ := CreateSignature("POST", url, ts, nonce, metaStr, c.apiPriKey)
+	signature, err := CreateSignature("POST", url, ts, nonce, metaStr, c.signer)
+	if err != nil {
+		err = fmt.Errorf("请求签名失败:%v", err)
+		return
+	}
 	reqBody := fmt.Sprintf("--%s\r\nContent-Disposition: form-data; name=\"meta\";\r\nContent-Type: application/json\r\n\r\n%s\r\n--%s\r\nContent-Disposition: form-data; name=\"file\"; filename=\"%s\";\r\nContent-Type: %s\r\n\r\n%s\r\n--%s--", BOUNDARY, metaStr, BOUNDARY, fName, fileType, fBytes, BOUNDARY)
 	h := &http.Client{Timeout: c.timeout}
 	requestUrl := c.baseUrl + url
@@ -250,12 +316,9 @@ func (c MerchantApiClient) doFormUpload(ctx context.Context, url string, fBytes
 		return
 	}
 	// 验证resp签名
-	wechatSignature := rawResp.Header.Get("Wechatpay-Signature")
-	wechatNonce := rawResp.Header.Get("Wechatpay-Nonce")
-	timestamp := rawResp.Header.Get("Wechatpay-Timestamp")
-	wechatSerial := rawResp.Header.Get("Wechatpay-Serial")
-	if !VerifyWechatSignature(timestamp, wechatNonce, resp, wechatSignature, c.platformCertMap.GetPublicKey(wechatSerial)) {
-		err = errors.New("resp签名错误")
+	err = c.verifyWechatSignature(rawResp.Header, resp)
+	if err != nil {
+		resp = nil
 		return
 	}
 	return
@@ -0,0 +1,222 @@
+package wxmch_api
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+/*
+	商户API证书和私钥加载
+		apiclient_key.pem（PKCS#1/PKCS#8）
+		apiclient_cert.pem
+		apiclient_cert.p12
+*/
+
+// 商户API证书和私钥
+type MerchantCredential struct {
+	// 商户api证书私钥
+	PrivateKey *rsa.PrivateKey
+	// 商户api证书
+	Certificate *x509.Certificate
+	// 商户api证书序列号
+	SerialNo string
+	// 证书过期时间
+	NotAfter time.Time
+}
+
+// 从PEM内容加载商户私钥，支持PKCS#1(RSA PRIVATE KEY)和PKCS#8(PRIVATE KEY)
+func LoadPrivateKey(keyContent string) (priKey *rsa.PrivateKey, err error) {
+	block, _ := pem.Decode([]byte(keyContent))
+	if block == nil {
+		err = errors.New("private key error")
+		return
+	}
+	priKey, err = parsePrivateKeyBytes(block.Bytes)
+	return
+}
+
+// 从文件加载商户私钥
+func LoadPrivateKeyFromFile(path string) (priKey *rsa.PrivateKey, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	priKey, err = LoadPrivateKey(string(content))
+	return
+}
+
+// 依次尝试PKCS#1和PKCS#8格式
+func parsePrivateKeyBytes(der []byte) (priKey *rsa.PrivateKey, err error) {
+	priKey, err = x509.ParsePKCS1PrivateKey(der)
+	if err == nil {
+		return
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return
+	}
+	priKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		err = errors.New("private key 不是rsa格式")
+		return
+	}
+	return
+}
+
+// 从PEM内容加载证书
+func LoadCertificate(certContent string) (cert *x509.Certificate, err error) {
+	block, _ := pem.Decode([]byte(certContent))
+	if block == nil {
+		err = errors.New("certificate error")
+		return
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	return
+}
+
+// 从文件加载证书
+func LoadCertificateFromFile(path string) (cert *x509.Certificate, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	cert, err = LoadCertificate(string(content))
+	return
+}
+
+// 获取证书序列号，格式与微信支付商户平台展示的一致（大写十六进制）
+func GetCertificateSerialNo(cert *x509.Certificate) string {
+	return fmt.Sprintf("%X", cert.SerialNumber)
+}
+
+// 检查私钥与证书是否匹配
+func CheckKeyMatchesCertificate(priKey *rsa.PrivateKey, cert *x509.Certificate) (err error) {
+	pubKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		err = errors.New("证书公钥不是rsa格式")
+		return
+	}
+	if pubKey.E != priKey.E || pubKey.N.Cmp(priKey.N) != 0 {
+		err = fmt.Errorf("私钥与证书%s不匹配", GetCertificateSerialNo(cert))
+		return
+	}
+	return
+}
+
+func newMerchantCredential(priKey *rsa.PrivateKey, cert *x509.Certificate) (cred *MerchantCredential, err error) {
+	err = CheckKeyMatchesCertificate(priKey, cert)
+	if err != nil {
+		return
+	}
+	cred = &MerchantCredential{
+		PrivateKey:  priKey,
+		Certificate: cert,
+		SerialNo:    GetCertificateSerialNo(cert),
+		NotAfter:    cert.NotAfter,
+	}
+	return
+}
+
+// 从apiclient_cert.pem和apiclient_key.pem内容加载商户证书，并检查私钥与证书是否匹配
+func LoadMerchantCredential(certContent string, keyContent string) (cred *MerchantCredential, err error) {
+	cert, err := LoadCertificate(certContent)
+	if err != nil {
+		return
+	}
+	priKey, err := LoadPrivateKey(keyContent)
+	if err != nil {
+		return
+	}
+	cred, err = newMerchantCredential(priKey, cert)
+	return
+}
+
+// 从apiclient_cert.pem和apiclient_key.pem文件加载商户证书
+func LoadMerchantCredentialFromFile(certPath string, keyPath string) (cred *MerchantCredential, err error) {
+	cert, err := LoadCertificateFromFile(certPath)
+	if err != nil {
+		return
+	}
+	priKey, err := LoadPrivateKeyFromFile(keyPath)
+	if err != nil {
+		return
+	}
+	cred, err = newMerchantCredential(priKey, cert)
+	return
+}
+
+// 从apiclient_cert.p12内容加载商户证书，password默认是商户号
+func LoadMerchantCredentialFromP12(content []byte, password string) (cred *MerchantCredential, err error) {
+	cert, priKey, err := parseP12(content, password)
+	if err != nil {
+		return
+	}
+	cred, err = newMerchantCredential(priKey, cert)
+	return
+}
+
+// 从apiclient_cert.p12文件加载商户证书
+func LoadMerchantCredentialFromP12File(path string, password string) (cred *MerchantCredential, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	cred, err = LoadMerchantCredentialFromP12(content, password)
+	return
+}
+
+func parseP12(content []byte, password string) (cert *x509.Certificate, priKey *rsa.PrivateKey, err error) {
+	blocks, err := pkcs12.ToPEM(content, password)
+	if err != nil {
+		return
+	}
+	var certs []*x509.Certificate
+	for _, b := range blocks {
+		switch b.Type {
+		case "CERTIFICATE":
+			var c *x509.Certificate
+			c, err = x509.ParseCertificate(b.Bytes)
+			if err != nil {
+				return
+			}
+			certs = append(certs, c)
+		case "PRIVATE KEY", "RSA PRIVATE KEY":
+			priKey, err = parsePrivateKeyBytes(b.Bytes)
+			if err != nil {
+				return
+			}
+		}
+	}
+	if len(certs) == 0 {
+		err = errors.New("p12文件中没有证书")
+		return
+	}
+	if priKey == nil {
+		err = errors.New("p12文件中没有私钥")
+		return
+	}
+	cert = pickP12LeafCertificate(certs, priKey)
+	return
+}
+
+// p12中可能带有CA证书链，优先使用与私钥匹配的证书，其次是第一个非CA证书
+func pickP12LeafCertificate(certs []*x509.Certificate, priKey *rsa.PrivateKey) *x509.Certificate {
+	for _, c := range certs {
+		if CheckKeyMatchesCertificate(priKey, c) == nil {
+			return c
+		}
+	}
+	for _, c := range certs {
+		if !c.IsCA {
+			return c
+		}
+	}
+	return certs[0]
+}
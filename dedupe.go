@@ -0,0 +1,206 @@
+package wxmch_api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+	回调通知去重
+	微信支付在收到成功应答之前会重复发送通知，按通知ID和业务主键去重，保证业务处理只执行一次
+*/
+
+var ErrNotificationProcessing = errors.New("相同的通知正在处理中")
+
+// 去重存储
+type DedupeStore interface {
+	// key是否已经处理过
+	Exists(key string) (exists bool, err error)
+	// 记录key已经处理
+	Save(key string) (err error)
+}
+
+// 内存去重存储，进程重启后失效
+type memoryDedupeStore struct {
+	mu   sync.RWMutex
+	keys map[string]struct{}
+}
+
+func NewMemoryDedupeStore() DedupeStore {
+	return &memoryDedupeStore{keys: make(map[string]struct{})}
+}
+
+func (s *memoryDedupeStore) Exists(key string) (exists bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists = s.keys[key]
+	return
+}
+
+func (s *memoryDedupeStore) Save(key string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = struct{}{}
+	return
+}
+
+// 文件去重存储，每行一个key，打开时全部加载到内存
+type FileDedupeStore struct {
+	mu   sync.RWMutex
+	file *os.File
+	keys map[string]struct{}
+}
+
+// 打开文件去重存储，文件不存在时创建
+func NewFileDedupeStore(path string) (store *FileDedupeStore, err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	keys := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			keys[line] = struct{}{}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		_ = f.Close()
+		return
+	}
+	store = &FileDedupeStore{file: f, keys: keys}
+	return
+}
+
+func (s *FileDedupeStore) Exists(key string) (exists bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists = s.keys[key]
+	return
+}
+
+func (s *FileDedupeStore) Save(key string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key]; ok {
+		return
+	}
+	_, err = s.file.WriteString(key + "\n")
+	if err != nil {
+		return
+	}
+	err = s.file.Sync()
+	if err != nil {
+		return
+	}
+	s.keys[key] = struct{}{}
+	return
+}
+
+func (s *FileDedupeStore) Close() error {
+	return s.file.Close()
+}
+
+// 通知去重
+type NotificationDeduper struct {
+	store DedupeStore
+	mu    sync.Mutex
+	// 正在处理中的key
+	processing map[string]struct{}
+}
+
+func NewNotificationDeduper(store DedupeStore) *NotificationDeduper {
+	return &NotificationDeduper{store: store, processing: make(map[string]struct{})}
+}
+
+// 通知的去重key：通知ID，以及通知类型+业务主键（退款单号/分账单号+接收方/微信订单号）
+func NotificationDedupeKeys(n Notification, plainText []byte) (keys []string) {
+	keys = append(keys, "notification:"+n.ID)
+	var biz struct {
+		TransactionID string `json:"transaction_id"`
+		RefundID      string `json:"refund_id"`
+		OrderID       string `json:"order_id"`
+		// 分账动账通知每个接收方单独通知一次
+		Receiver struct {
+			Type    string `json:"type"`
+			Account string `json:"account"`
+		} `json:"receiver"`
+	}
+	if err := json.Unmarshal(plainText, &biz); err != nil {
+		return
+	}
+	// 退款和分账通知中也有transaction_id，需要优先使用更具体的单号
+	switch {
+	case biz.RefundID != "":
+		keys = append(keys, "refund:"+n.EventType+":"+biz.RefundID)
+	case biz.OrderID != "":
+		keys = append(keys, "profitsharing:"+n.EventType+":"+biz.OrderID+":"+biz.Receiver.Type+":"+biz.Receiver.Account)
+	case biz.TransactionID != "":
+		keys = append(keys, "transaction:"+n.EventType+":"+biz.TransactionID)
+	}
+	return
+}
+
+// 去重执行handler
+// 重复的通知返回duplicated=true，不执行handler；handler返回错误时不记录，微信支付重发时会再次执行
+func (d *NotificationDeduper) Do(n Notification, plainText []byte, handler func() error) (duplicated bool, err error) {
+	keys := NotificationDedupeKeys(n, plainText)
+	d.mu.Lock()
+	for _, key := range keys {
+		if _, ok := d.processing[key]; ok {
+			d.mu.Unlock()
+			err = ErrNotificationProcessing
+			return
+		}
+	}
+	for _, key := range keys {
+		d.processing[key] = struct{}{}
+	}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		for _, key := range keys {
+			delete(d.processing, key)
+		}
+		d.mu.Unlock()
+	}()
+
+	for _, key := range keys {
+		duplicated, err = d.store.Exists(key)
+		if err != nil || duplicated {
+			return
+		}
+	}
+	err = handler()
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		err = d.store.Save(key)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// 验签、解密并去重处理回调通知，handler对每个事件只执行一次
+func (c MerchantApiClient) HandleNotification(header http.Header, body []byte, deduper *NotificationDeduper, handler func(n *Notification, plainText []byte) error) (duplicated bool, err error) {
+	n, err := c.ParseNotification(header, body)
+	if err != nil {
+		return
+	}
+	plainText, err := c.GetResourcePlainText(n.Resource)
+	if err != nil {
+		return
+	}
+	duplicated, err = deduper.Do(*n, plainText, func() error {
+		return handler(n, plainText)
+	})
+	return
+}
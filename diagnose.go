@@ -0,0 +1,210 @@
+package wxmch_api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+/*
+	客户端自检
+		APIv3密钥长度
+		商户私钥与商户证书序列号是否匹配
+		APIv3密钥能否解密平台证书
+		平台证书/微信支付公钥配置
+		平台证书有效期
+		本地时钟偏差
+*/
+
+// 自检结果状态
+type DiagnoseStatus string
+
+const DiagnoseStatusPass DiagnoseStatus = "PASS"
+const DiagnoseStatusWarn DiagnoseStatus = "WARN"
+const DiagnoseStatusFail DiagnoseStatus = "FAIL"
+const DiagnoseStatusSkip DiagnoseStatus = "SKIP"
+
+// 自检项
+const DiagnoseApiSecretLength = "api_secret_length"
+const DiagnoseMerchantSignature = "merchant_signature"
+const DiagnoseApiSecretDecrypt = "api_secret_decrypt"
+const DiagnoseResponseSignature = "response_signature"
+const DiagnosePlatformSerialNo = "platform_serial_no"
+const DiagnosePlatformCertExpiry = "platform_cert_expiry"
+const DiagnoseClockSkew = "clock_skew"
+
+// 平台证书剩余有效期小于该值时告警
+const diagnoseCertExpiryWarning = 30 * 24 * time.Hour
+
+// 本地时钟与微信支付服务器时间偏差的上限
+const diagnoseMaxClockSkew = 5 * time.Minute
+
+type DiagnoseItem struct {
+	// 自检项
+	Name string `json:"name"`
+	// 自检结果
+	Status DiagnoseStatus `json:"status"`
+	// 说明
+	Message string `json:"message"`
+}
+
+type DiagnoseReport struct {
+	// 商户号
+	MchID string `json:"mchid"`
+	// 自检项列表，按检查顺序排列
+	Items []DiagnoseItem `json:"items"`
+	// 本地时间减去微信支付服务器时间
+	ClockSkew time.Duration `json:"clock_skew"`
+}
+
+// 所有自检项都没有失败
+func (r *DiagnoseReport) OK() bool {
+	for _, item := range r.Items {
+		if item.Status == DiagnoseStatusFail {
+			return false
+		}
+	}
+	return true
+}
+
+// 获取指定自检项
+func (r *DiagnoseReport) Item(name string) (item DiagnoseItem, ok bool) {
+	for _, item = range r.Items {
+		if item.Name == name {
+			ok = true
+			return
+		}
+	}
+	item = DiagnoseItem{}
+	return
+}
+
+func (r *DiagnoseReport) add(name string, status DiagnoseStatus, format string, args ...interface{}) {
+	r.Items = append(r.Items, DiagnoseItem{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// 无法检查的自检项标记为跳过
+func (r *DiagnoseReport) skip(reason string, names ...string) {
+	for _, name := range names {
+		r.add(name, DiagnoseStatusSkip, "%s", reason)
+	}
+}
+
+// 客户端自检，依次检查商户配置，返回结构化的自检报告
+// err只在请求/v3/certificates网络失败时返回，配置问题都体现在报告中
+func (c MerchantApiClient) Diagnose(ctx context.Context) (report *DiagnoseReport, err error) {
+	report = &DiagnoseReport{MchID: c.mchId}
+	// APIv3密钥必须是32字节
+	if len(c.apiSecret) == 32 {
+		report.add(DiagnoseApiSecretLength, DiagnoseStatusPass, "APIv3密钥长度正确")
+	} else {
+		report.add(DiagnoseApiSecretLength, DiagnoseStatusFail, "APIv3密钥长度为%d字节，应为32字节", len(c.apiSecret))
+	}
+
+	rawResp, err := c.doRequestWithOutWxSerial(ctx, "GET", "/v3/certificates", nil, nil)
+	if err != nil {
+		report.skip("请求/v3/certificates失败", DiagnoseMerchantSignature, DiagnoseApiSecretDecrypt, DiagnoseResponseSignature, DiagnosePlatformSerialNo, DiagnosePlatformCertExpiry, DiagnoseClockSkew)
+		return
+	}
+	body, err := ioutil.ReadAll(rawResp.Body)
+	_ = rawResp.Body.Close()
+	if err != nil {
+		report.skip("读取/v3/certificates应答失败", DiagnoseMerchantSignature, DiagnoseApiSecretDecrypt, DiagnoseResponseSignature, DiagnosePlatformSerialNo, DiagnosePlatformCertExpiry, DiagnoseClockSkew)
+		return
+	}
+
+	// 时钟偏差以响应的Date为准
+	if serverTime, e := http.ParseTime(rawResp.Header.Get("Date")); e != nil {
+		report.add(DiagnoseClockSkew, DiagnoseStatusSkip, "响应中没有Date")
+	} else {
+		report.ClockSkew = time.Now().Sub(serverTime)
+		skew := report.ClockSkew
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > diagnoseMaxClockSkew {
+			report.add(DiagnoseClockSkew, DiagnoseStatusFail, "本地时间与微信支付服务器相差%s", report.ClockSkew.Round(time.Second))
+		} else {
+			report.add(DiagnoseClockSkew, DiagnoseStatusPass, "本地时间与微信支付服务器相差%s", report.ClockSkew.Round(time.Second))
+		}
+	}
+
+	// 商户私钥或商户证书序列号错误时，微信支付返回401；其他错误无法判断商户签名是否正确
+	if e := buildErrorIfExist(rawResp.StatusCode, body); e != nil {
+		if rawResp.StatusCode == http.StatusUnauthorized {
+			report.add(DiagnoseMerchantSignature, DiagnoseStatusFail, "商户私钥与商户证书序列号%s不匹配:%s", c.certSerialNo, e.Error())
+		} else {
+			report.add(DiagnoseMerchantSignature, DiagnoseStatusSkip, "下载平台证书失败:%s", e.Error())
+		}
+		report.skip("下载平台证书失败，无法检查", DiagnoseApiSecretDecrypt, DiagnoseResponseSignature, DiagnosePlatformSerialNo, DiagnosePlatformCertExpiry)
+		return
+	}
+	report.add(DiagnoseMerchantSignature, DiagnoseStatusPass, "商户私钥与商户证书序列号%s匹配", c.certSerialNo)
+
+	var certs GetCertificatesResp
+	if e := json.Unmarshal(body, &certs); e != nil {
+		report.add(DiagnoseApiSecretDecrypt, DiagnoseStatusFail, "平台证书列表解析失败:%s", e.Error())
+		report.skip("平台证书列表解析失败，无法检查", DiagnoseResponseSignature, DiagnosePlatformSerialNo, DiagnosePlatformCertExpiry)
+		return
+	}
+	decrypted := true
+	for _, cert := range certs.Data {
+		encrypted := cert.EncryptCertificate
+		_, e := decryptCiphertextWithGCM(encrypted.AssociatedData, encrypted.Nonce, encrypted.Ciphertext, c.apiSecret)
+		if e != nil {
+			report.add(DiagnoseApiSecretDecrypt, DiagnoseStatusFail, "APIv3密钥无法解密平台证书%s:%s", cert.SerialNo, e.Error())
+			decrypted = false
+			break
+		}
+	}
+	if decrypted {
+		report.add(DiagnoseApiSecretDecrypt, DiagnoseStatusPass, "APIv3密钥可以解密平台证书")
+	}
+
+	// 用配置的平台证书/微信支付公钥验证/v3/certificates的应答签名，没有配置平台证书map时无法验签
+	// 自检不记录应答的nonce，避免影响正常请求的防重放检查
+	if c.platformCertMap == nil {
+		report.add(DiagnoseResponseSignature, DiagnoseStatusFail, "没有配置平台证书map，无法验证应答签名")
+	} else if e := c.verifyWechatSignatureWithoutNonce(rawResp.Header, body); e != nil {
+		report.add(DiagnoseResponseSignature, DiagnoseStatusFail, "应答验签失败:%s", e.Error())
+	} else {
+		report.add(DiagnoseResponseSignature, DiagnoseStatusPass, "应答验签通过")
+	}
+
+	if c.platformCertMap == nil || c.platformCertMap.GetPublicKey(c.platformSerialNo) == nil {
+		report.add(DiagnosePlatformSerialNo, DiagnoseStatusFail, "平台证书map中没有%s", c.platformSerialNo)
+	} else {
+		report.add(DiagnosePlatformSerialNo, DiagnoseStatusPass, "平台证书map中存在%s", c.platformSerialNo)
+	}
+
+	// 微信支付公钥没有有效期
+	if IsPublicKeyID(c.platformSerialNo) {
+		report.add(DiagnosePlatformCertExpiry, DiagnoseStatusSkip, "%s是微信支付公钥", c.platformSerialNo)
+		return
+	}
+	for _, cert := range certs.Data {
+		if cert.SerialNo != c.platformSerialNo {
+			continue
+		}
+		expireTime, e := time.Parse(time.RFC3339, cert.ExpireTime)
+		if e != nil {
+			report.add(DiagnosePlatformCertExpiry, DiagnoseStatusSkip, "平台证书过期时间格式错误:%s", cert.ExpireTime)
+			return
+		}
+		left := time.Until(expireTime)
+		switch {
+		case left <= 0:
+			report.add(DiagnosePlatformCertExpiry, DiagnoseStatusFail, "平台证书%s已于%s过期", cert.SerialNo, cert.ExpireTime)
+		case left < diagnoseCertExpiryWarning:
+			report.add(DiagnosePlatformCertExpiry, DiagnoseStatusWarn, "平台证书%s将于%s过期", cert.SerialNo, cert.ExpireTime)
+		default:
+			report.add(DiagnosePlatformCertExpiry, DiagnoseStatusPass, "平台证书%s有效期至%s", cert.SerialNo, cert.ExpireTime)
+		}
+		return
+	}
+	report.add(DiagnosePlatformCertExpiry, DiagnoseStatusFail, "微信支付返回的平台证书中没有%s", c.platformSerialNo)
+	return
+}
@@ -34,3 +34,17 @@ func (e *ErrBody) IsIdempotent() bool {
 type errIdempotent interface {
 	IsIdempotent() bool
 }
+
+// 系统繁忙或频率限制，可以用相同的参数重试
+func (e *ErrBody) IsRetryable() bool {
+	return e.Code == "SYSTEM_ERROR" || e.Code == "FREQUENCY_LIMITED"
+}
+
+// 网络错误、应答超时和可以重试的错误码，结果不确定，需要用相同的单号重试
+func isRetryableError(err error) bool {
+	e, ok := err.(*ErrBody)
+	if !ok {
+		return true
+	}
+	return e.IsRetryable()
+}
@@ -0,0 +1,562 @@
+package wxmch_api
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+/*
+	服务接口的fake实现，用于单元测试
+	按需设置XxxFunc，未设置的方法返回ErrNotFaked
+*/
+
+// 调用了未设置的fake方法
+type ErrNotFaked struct {
+	Method string
+}
+
+func (e *ErrNotFaked) Error() string {
+	return fmt.Sprintf("fake方法%s未设置", e.Method)
+}
+
+// 普通支付服务的fake实现
+type FakeTransactionService struct {
+	JsApiPrepayFunc                   func(context.Context, JsApiPrepayRequest) (*PrepayPayResponse, error)
+	PayResultQueryByTransactionIDFunc func(context.Context, QueryPayResultByTransactionIDRequest) (*QueryPayResultResponse, error)
+	PayResultQueryByOutRequestNoFunc  func(context.Context, QueryPayResultByOutRequestNoRequest) (*QueryPayResultResponse, error)
+	GenJsApiPayRequestFunc            func(JsApiPayRequest) (*JsApiPayResponse, error)
+	CloseFunc                         func(context.Context, CloseOrderRequest) error
+}
+
+func (f *FakeTransactionService) JsApiPrepay(ctx context.Context, req JsApiPrepayRequest) (resp *PrepayPayResponse, err error) {
+	if f.JsApiPrepayFunc == nil {
+		err = &ErrNotFaked{Method: "JsApiPrepay"}
+		return
+	}
+	resp, err = f.JsApiPrepayFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransactionService) PayResultQueryByTransactionID(ctx context.Context, req QueryPayResultByTransactionIDRequest) (resp *QueryPayResultResponse, err error) {
+	if f.PayResultQueryByTransactionIDFunc == nil {
+		err = &ErrNotFaked{Method: "PayResultQueryByTransactionID"}
+		return
+	}
+	resp, err = f.PayResultQueryByTransactionIDFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransactionService) PayResultQueryByOutRequestNo(ctx context.Context, req QueryPayResultByOutRequestNoRequest) (resp *QueryPayResultResponse, err error) {
+	if f.PayResultQueryByOutRequestNoFunc == nil {
+		err = &ErrNotFaked{Method: "PayResultQueryByOutRequestNo"}
+		return
+	}
+	resp, err = f.PayResultQueryByOutRequestNoFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransactionService) GenJsApiPayRequest(req JsApiPayRequest) (resp *JsApiPayResponse, err error) {
+	if f.GenJsApiPayRequestFunc == nil {
+		err = &ErrNotFaked{Method: "GenJsApiPayRequest"}
+		return
+	}
+	resp, err = f.GenJsApiPayRequestFunc(req)
+	return
+}
+
+func (f *FakeTransactionService) Close(ctx context.Context, req CloseOrderRequest) (err error) {
+	if f.CloseFunc == nil {
+		err = &ErrNotFaked{Method: "Close"}
+		return
+	}
+	err = f.CloseFunc(ctx, req)
+	return
+}
+
+// 退款服务的fake实现
+type FakeRefundService struct {
+	RefundApplyFunc              func(context.Context, RefundRequest) (*RefundResponse, error)
+	QueryRefundByIDFunc          func(context.Context, QueryRefundByIDRequest) (*QueryRefundResponse, error)
+	QueryRefundByOutRefundNoFunc func(context.Context, QueryRefundByOutRefundNoRequest) (*QueryRefundResponse, error)
+}
+
+func (f *FakeRefundService) RefundApply(ctx context.Context, req RefundRequest) (resp *RefundResponse, err error) {
+	if f.RefundApplyFunc == nil {
+		err = &ErrNotFaked{Method: "RefundApply"}
+		return
+	}
+	resp, err = f.RefundApplyFunc(ctx, req)
+	return
+}
+
+func (f *FakeRefundService) QueryRefundByID(ctx context.Context, req QueryRefundByIDRequest) (resp *QueryRefundResponse, err error) {
+	if f.QueryRefundByIDFunc == nil {
+		err = &ErrNotFaked{Method: "QueryRefundByID"}
+		return
+	}
+	resp, err = f.QueryRefundByIDFunc(ctx, req)
+	return
+}
+
+func (f *FakeRefundService) QueryRefundByOutRefundNo(ctx context.Context, req QueryRefundByOutRefundNoRequest) (resp *QueryRefundResponse, err error) {
+	if f.QueryRefundByOutRefundNoFunc == nil {
+		err = &ErrNotFaked{Method: "QueryRefundByOutRefundNo"}
+		return
+	}
+	resp, err = f.QueryRefundByOutRefundNoFunc(ctx, req)
+	return
+}
+
+// 分账服务的fake实现
+type FakeProfitSharingService struct {
+	ProfitShareApplyFunc              func(context.Context, ProfitShareApplyRequest) (*ProfitShareApplyResponse, error)
+	ProfitShareQueryFunc              func(context.Context, ProfitShareQueryRequest) (*ProfitShareQueryResponse, error)
+	ProfitReturnApplyFunc             func(context.Context, ProfitReturnApplyRequest) (*ProfitReturnApplyResponse, error)
+	ProfitReturnQueryFunc             func(context.Context, ProfitReturnQueryRequest) (ProfitReturnQueryResponse, error)
+	ProfitShareUnSplitAmountQueryFunc func(context.Context, ProfitShareUnSplitAmountQueryRequest) (*ProfitShareUnSplitAmountQueryResponse, error)
+	ProfitShareFinishFunc             func(context.Context, ProfitShareFinishRequest) (*ProfitShareFinishResponse, error)
+	ReceiversAddFunc                  func(context.Context, ReceiversAddRequest) (*ReceiversAddResponse, error)
+	ReceiversDeleteFunc               func(context.Context, ReceiversDeleteRequest) (*ReceiversDeleteResponse, error)
+	ProfitShareBillFunc               func(context.Context, ProfitShareBillRequest) (*BillDownloadInfo, error)
+	EcommerceProfitShareBillFunc      func(context.Context, ProfitShareBillRequest) (*BillDownloadInfo, error)
+}
+
+func (f *FakeProfitSharingService) ProfitShareApply(ctx context.Context, req ProfitShareApplyRequest) (resp *ProfitShareApplyResponse, err error) {
+	if f.ProfitShareApplyFunc == nil {
+		err = &ErrNotFaked{Method: "ProfitShareApply"}
+		return
+	}
+	resp, err = f.ProfitShareApplyFunc(ctx, req)
+	return
+}
+
+func (f *FakeProfitSharingService) ProfitShareQuery(ctx context.Context, req ProfitShareQueryRequest) (resp *ProfitShareQueryResponse, err error) {
+	if f.ProfitShareQueryFunc == nil {
+		err = &ErrNotFaked{Method: "ProfitShareQuery"}
+		return
+	}
+	resp, err = f.ProfitShareQueryFunc(ctx, req)
+	return
+}
+
+func (f *FakeProfitSharingService) ProfitReturnApply(ctx context.Context, req ProfitReturnApplyRequest) (resp *ProfitReturnApplyResponse, err error) {
+	if f.ProfitReturnApplyFunc == nil {
+		err = &ErrNotFaked{Method: "ProfitReturnApply"}
+		return
+	}
+	resp, err = f.ProfitReturnApplyFunc(ctx, req)
+	return
+}
+
+func (f *FakeProfitSharingService) ProfitReturnQuery(ctx context.Context, req ProfitReturnQueryRequest) (resp ProfitReturnQueryResponse, err error) {
+	if f.ProfitReturnQueryFunc == nil {
+		err = &ErrNotFaked{Method: "ProfitReturnQuery"}
+		return
+	}
+	resp, err = f.ProfitReturnQueryFunc(ctx, req)
+	return
+}
+
+func (f *FakeProfitSharingService) ProfitShareUnSplitAmountQuery(ctx context.Context, req ProfitShareUnSplitAmountQueryRequest) (resp *ProfitShareUnSplitAmountQueryResponse, err error) {
+	if f.ProfitShareUnSplitAmountQueryFunc == nil {
+		err = &ErrNotFaked{Method: "ProfitShareUnSplitAmountQuery"}
+		return
+	}
+	resp, err = f.ProfitShareUnSplitAmountQueryFunc(ctx, req)
+	return
+}
+
+func (f *FakeProfitSharingService) ProfitShareFinish(ctx context.Context, req ProfitShareFinishRequest) (resp *ProfitShareFinishResponse, err error) {
+	if f.ProfitShareFinishFunc == nil {
+		err = &ErrNotFaked{Method: "ProfitShareFinish"}
+		return
+	}
+	resp, err = f.ProfitShareFinishFunc(ctx, req)
+	return
+}
+
+func (f *FakeProfitSharingService) ReceiversAdd(ctx context.Context, req ReceiversAddRequest) (resp *ReceiversAddResponse, err error) {
+	if f.ReceiversAddFunc == nil {
+		err = &ErrNotFaked{Method: "ReceiversAdd"}
+		return
+	}
+	resp, err = f.ReceiversAddFunc(ctx, req)
+	return
+}
+
+func (f *FakeProfitSharingService) ReceiversDelete(ctx context.Context, req ReceiversDeleteRequest) (resp *ReceiversDeleteResponse, err error) {
+	if f.ReceiversDeleteFunc == nil {
+		err = &ErrNotFaked{Method: "ReceiversDelete"}
+		return
+	}
+	resp, err = f.ReceiversDeleteFunc(ctx, req)
+	return
+}
+
+func (f *FakeProfitSharingService) ProfitShareBill(ctx context.Context, req ProfitShareBillRequest) (resp *BillDownloadInfo, err error) {
+	if f.ProfitShareBillFunc == nil {
+		err = &ErrNotFaked{Method: "ProfitShareBill"}
+		return
+	}
+	resp, err = f.ProfitShareBillFunc(ctx, req)
+	return
+}
+
+func (f *FakeProfitSharingService) EcommerceProfitShareBill(ctx context.Context, req ProfitShareBillRequest) (resp *BillDownloadInfo, err error) {
+	if f.EcommerceProfitShareBillFunc == nil {
+		err = &ErrNotFaked{Method: "EcommerceProfitShareBill"}
+		return
+	}
+	resp, err = f.EcommerceProfitShareBillFunc(ctx, req)
+	return
+}
+
+// 批量转账到零钱服务的fake实现
+type FakeTransferService struct {
+	BatchTransferFunc              func(context.Context, BatchTransferRequest) (*BatchTransferResponse, error)
+	BatchTransferQueryByOutNoFunc  func(context.Context, BatchTransferQueryByOutNoRequest) (*BatchTransferQueryByOutNoResponse, error)
+	BatchTransferQueryByIDFunc     func(context.Context, BatchTransferQueryByIDRequest) (*BatchTransferQueryByIDResponse, error)
+	TransferDetailQueryByOutNoFunc func(context.Context, TransferDetailQueryByOutNoRequest) (*TransferDetailQueryResponse, error)
+	TransferDetailQueryByIDFunc    func(context.Context, TransferDetailQueryByIDRequest) (*TransferDetailQueryResponse, error)
+	TransferBillReceiptApplyFunc   func(context.Context, TransferBillReceiptApplyRequest) (*TransferBillReceipt, error)
+	TransferBillReceiptQueryFunc   func(context.Context, TransferBillReceiptQueryRequest) (*TransferBillReceipt, error)
+	TransferDetailReceiptApplyFunc func(context.Context, TransferDetailReceiptRequest) (*TransferDetailReceipt, error)
+	TransferDetailReceiptQueryFunc func(context.Context, TransferDetailReceiptRequest) (*TransferDetailReceipt, error)
+}
+
+func (f *FakeTransferService) BatchTransfer(ctx context.Context, req BatchTransferRequest) (resp *BatchTransferResponse, err error) {
+	if f.BatchTransferFunc == nil {
+		err = &ErrNotFaked{Method: "BatchTransfer"}
+		return
+	}
+	resp, err = f.BatchTransferFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransferService) BatchTransferQueryByOutNo(ctx context.Context, req BatchTransferQueryByOutNoRequest) (resp *BatchTransferQueryByOutNoResponse, err error) {
+	if f.BatchTransferQueryByOutNoFunc == nil {
+		err = &ErrNotFaked{Method: "BatchTransferQueryByOutNo"}
+		return
+	}
+	resp, err = f.BatchTransferQueryByOutNoFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransferService) BatchTransferQueryByID(ctx context.Context, req BatchTransferQueryByIDRequest) (resp *BatchTransferQueryByIDResponse, err error) {
+	if f.BatchTransferQueryByIDFunc == nil {
+		err = &ErrNotFaked{Method: "BatchTransferQueryByID"}
+		return
+	}
+	resp, err = f.BatchTransferQueryByIDFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransferService) TransferDetailQueryByOutNo(ctx context.Context, req TransferDetailQueryByOutNoRequest) (resp *TransferDetailQueryResponse, err error) {
+	if f.TransferDetailQueryByOutNoFunc == nil {
+		err = &ErrNotFaked{Method: "TransferDetailQueryByOutNo"}
+		return
+	}
+	resp, err = f.TransferDetailQueryByOutNoFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransferService) TransferDetailQueryByID(ctx context.Context, req TransferDetailQueryByIDRequest) (resp *TransferDetailQueryResponse, err error) {
+	if f.TransferDetailQueryByIDFunc == nil {
+		err = &ErrNotFaked{Method: "TransferDetailQueryByID"}
+		return
+	}
+	resp, err = f.TransferDetailQueryByIDFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransferService) TransferBillReceiptApply(ctx context.Context, req TransferBillReceiptApplyRequest) (resp *TransferBillReceipt, err error) {
+	if f.TransferBillReceiptApplyFunc == nil {
+		err = &ErrNotFaked{Method: "TransferBillReceiptApply"}
+		return
+	}
+	resp, err = f.TransferBillReceiptApplyFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransferService) TransferBillReceiptQuery(ctx context.Context, req TransferBillReceiptQueryRequest) (resp *TransferBillReceipt, err error) {
+	if f.TransferBillReceiptQueryFunc == nil {
+		err = &ErrNotFaked{Method: "TransferBillReceiptQuery"}
+		return
+	}
+	resp, err = f.TransferBillReceiptQueryFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransferService) TransferDetailReceiptApply(ctx context.Context, req TransferDetailReceiptRequest) (resp *TransferDetailReceipt, err error) {
+	if f.TransferDetailReceiptApplyFunc == nil {
+		err = &ErrNotFaked{Method: "TransferDetailReceiptApply"}
+		return
+	}
+	resp, err = f.TransferDetailReceiptApplyFunc(ctx, req)
+	return
+}
+
+func (f *FakeTransferService) TransferDetailReceiptQuery(ctx context.Context, req TransferDetailReceiptRequest) (resp *TransferDetailReceipt, err error) {
+	if f.TransferDetailReceiptQueryFunc == nil {
+		err = &ErrNotFaked{Method: "TransferDetailReceiptQuery"}
+		return
+	}
+	resp, err = f.TransferDetailReceiptQueryFunc(ctx, req)
+	return
+}
+
+// 余额查询和提现服务的fake实现
+type FakeFundService struct {
+	SubMchBalanceQueryFunc                func(context.Context, SubMchBalanceQueryRequest) (*SubMchBalanceQueryResponse, error)
+	SubMchEndDayBalanceQueryFunc          func(context.Context, SubMchEndDayBalanceQueryRequest) (*SubMchEndDayBalanceQueryResponse, error)
+	PlatformBalanceQueryFunc              func(context.Context, PlatformBalanceQueryRequest) (*PlatformBalanceQueryResponse, error)
+	PlatformEndDayBalanceQueryFunc        func(context.Context, PlatformEndDayBalanceQueryRequest) (*PlatformEndDayBalanceQueryResponse, error)
+	SubMchWithdrawFunc                    func(context.Context, SubMchWithdrawRequest) (*SubMchWithdrawResponse, error)
+	SubMchWithdrawQueryByWithdrawIDFunc   func(context.Context, SubMchWithdrawQueryByWithdrawIDRequest) (*SubMchWithdrawQueryResponse, error)
+	SubMchWithdrawQueryByOutRequestNoFunc func(context.Context, SubMchWithdrawQueryByOutRequestNoRequest) (*SubMchWithdrawQueryResponse, error)
+}
+
+func (f *FakeFundService) SubMchBalanceQuery(ctx context.Context, req SubMchBalanceQueryRequest) (resp *SubMchBalanceQueryResponse, err error) {
+	if f.SubMchBalanceQueryFunc == nil {
+		err = &ErrNotFaked{Method: "SubMchBalanceQuery"}
+		return
+	}
+	resp, err = f.SubMchBalanceQueryFunc(ctx, req)
+	return
+}
+
+func (f *FakeFundService) SubMchEndDayBalanceQuery(ctx context.Context, req SubMchEndDayBalanceQueryRequest) (resp *SubMchEndDayBalanceQueryResponse, err error) {
+	if f.SubMchEndDayBalanceQueryFunc == nil {
+		err = &ErrNotFaked{Method: "SubMchEndDayBalanceQuery"}
+		return
+	}
+	resp, err = f.SubMchEndDayBalanceQueryFunc(ctx, req)
+	return
+}
+
+func (f *FakeFundService) PlatformBalanceQuery(ctx context.Context, req PlatformBalanceQueryRequest) (resp *PlatformBalanceQueryResponse, err error) {
+	if f.PlatformBalanceQueryFunc == nil {
+		err = &ErrNotFaked{Method: "PlatformBalanceQuery"}
+		return
+	}
+	resp, err = f.PlatformBalanceQueryFunc(ctx, req)
+	return
+}
+
+func (f *FakeFundService) PlatformEndDayBalanceQuery(ctx context.Context, req PlatformEndDayBalanceQueryRequest) (resp *PlatformEndDayBalanceQueryResponse, err error) {
+	if f.PlatformEndDayBalanceQueryFunc == nil {
+		err = &ErrNotFaked{Method: "PlatformEndDayBalanceQuery"}
+		return
+	}
+	resp, err = f.PlatformEndDayBalanceQueryFunc(ctx, req)
+	return
+}
+
+func (f *FakeFundService) SubMchWithdraw(ctx context.Context, req SubMchWithdrawRequest) (resp *SubMchWithdrawResponse, err error) {
+	if f.SubMchWithdrawFunc == nil {
+		err = &ErrNotFaked{Method: "SubMchWithdraw"}
+		return
+	}
+	resp, err = f.SubMchWithdrawFunc(ctx, req)
+	return
+}
+
+func (f *FakeFundService) SubMchWithdrawQueryByWithdrawID(ctx context.Context, req SubMchWithdrawQueryByWithdrawIDRequest) (resp *SubMchWithdrawQueryResponse, err error) {
+	if f.SubMchWithdrawQueryByWithdrawIDFunc == nil {
+		err = &ErrNotFaked{Method: "SubMchWithdrawQueryByWithdrawID"}
+		return
+	}
+	resp, err = f.SubMchWithdrawQueryByWithdrawIDFunc(ctx, req)
+	return
+}
+
+func (f *FakeFundService) SubMchWithdrawQueryByOutRequestNo(ctx context.Context, req SubMchWithdrawQueryByOutRequestNoRequest) (resp *SubMchWithdrawQueryResponse, err error) {
+	if f.SubMchWithdrawQueryByOutRequestNoFunc == nil {
+		err = &ErrNotFaked{Method: "SubMchWithdrawQueryByOutRequestNo"}
+		return
+	}
+	resp, err = f.SubMchWithdrawQueryByOutRequestNoFunc(ctx, req)
+	return
+}
+
+// 商户进件和结算账户服务的fake实现
+type FakeApplymentService struct {
+	ApplymentSubmitFunc              func(context.Context, SubmitApplymentRequest) (*SubmitApplymentResp, error)
+	ApplymentQueryByIDFunc           func(context.Context, QueryApplymentByIDRequest) (*ApplymentQueryResponse, error)
+	ApplymentQueryByOutRequestNoFunc func(context.Context, QueryApplymentByOutRequestNoRequest) (*ApplymentQueryResponse, error)
+	SettlementModifyFunc             func(context.Context, ModifySettlementRequest) (*ModifySettlementResponse, error)
+	SettlementApplicationQueryFunc   func(context.Context, QuerySettlementApplicationRequest) (*QuerySettlementApplicationResponse, error)
+	SettlementQueryFunc              func(context.Context, QuerySettlementRequest) (*QuerySettlementResponse, error)
+}
+
+func (f *FakeApplymentService) ApplymentSubmit(ctx context.Context, req SubmitApplymentRequest) (resp *SubmitApplymentResp, err error) {
+	if f.ApplymentSubmitFunc == nil {
+		err = &ErrNotFaked{Method: "ApplymentSubmit"}
+		return
+	}
+	resp, err = f.ApplymentSubmitFunc(ctx, req)
+	return
+}
+
+func (f *FakeApplymentService) ApplymentQueryByID(ctx context.Context, req QueryApplymentByIDRequest) (resp *ApplymentQueryResponse, err error) {
+	if f.ApplymentQueryByIDFunc == nil {
+		err = &ErrNotFaked{Method: "ApplymentQueryByID"}
+		return
+	}
+	resp, err = f.ApplymentQueryByIDFunc(ctx, req)
+	return
+}
+
+func (f *FakeApplymentService) ApplymentQueryByOutRequestNo(ctx context.Context, req QueryApplymentByOutRequestNoRequest) (resp *ApplymentQueryResponse, err error) {
+	if f.ApplymentQueryByOutRequestNoFunc == nil {
+		err = &ErrNotFaked{Method: "ApplymentQueryByOutRequestNo"}
+		return
+	}
+	resp, err = f.ApplymentQueryByOutRequestNoFunc(ctx, req)
+	return
+}
+
+func (f *FakeApplymentService) SettlementModify(ctx context.Context, req ModifySettlementRequest) (resp *ModifySettlementResponse, err error) {
+	if f.SettlementModifyFunc == nil {
+		err = &ErrNotFaked{Method: "SettlementModify"}
+		return
+	}
+	resp, err = f.SettlementModifyFunc(ctx, req)
+	return
+}
+
+func (f *FakeApplymentService) SettlementApplicationQuery(ctx context.Context, req QuerySettlementApplicationRequest) (resp *QuerySettlementApplicationResponse, err error) {
+	if f.SettlementApplicationQueryFunc == nil {
+		err = &ErrNotFaked{Method: "SettlementApplicationQuery"}
+		return
+	}
+	resp, err = f.SettlementApplicationQueryFunc(ctx, req)
+	return
+}
+
+func (f *FakeApplymentService) SettlementQuery(ctx context.Context, req QuerySettlementRequest) (resp *QuerySettlementResponse, err error) {
+	if f.SettlementQueryFunc == nil {
+		err = &ErrNotFaked{Method: "SettlementQuery"}
+		return
+	}
+	resp, err = f.SettlementQueryFunc(ctx, req)
+	return
+}
+
+// 银行组件服务的fake实现
+type FakeCapitalService struct {
+	SearchBanksByBankAccountFunc func(context.Context, SearchBanksByBankAccountRequest) (*SearchBanksByBankAccountResponse, error)
+	PersonalBankingListFunc      func(context.Context, BankListRequest) (*BankListResponse, error)
+	CorporateBankingListFunc     func(context.Context, BankListRequest) (*BankListResponse, error)
+	ProvinceListFunc             func(context.Context) (*ProvinceListResponse, error)
+	CityListFunc                 func(context.Context, CityListRequest) (*CityListResponse, error)
+	BankBranchListFunc           func(context.Context, BankBranchListRequest) (*BankBranchListResponse, error)
+}
+
+func (f *FakeCapitalService) SearchBanksByBankAccount(ctx context.Context, req SearchBanksByBankAccountRequest) (resp *SearchBanksByBankAccountResponse, err error) {
+	if f.SearchBanksByBankAccountFunc == nil {
+		err = &ErrNotFaked{Method: "SearchBanksByBankAccount"}
+		return
+	}
+	resp, err = f.SearchBanksByBankAccountFunc(ctx, req)
+	return
+}
+
+func (f *FakeCapitalService) PersonalBankingList(ctx context.Context, req BankListRequest) (resp *BankListResponse, err error) {
+	if f.PersonalBankingListFunc == nil {
+		err = &ErrNotFaked{Method: "PersonalBankingList"}
+		return
+	}
+	resp, err = f.PersonalBankingListFunc(ctx, req)
+	return
+}
+
+func (f *FakeCapitalService) CorporateBankingList(ctx context.Context, req BankListRequest) (resp *BankListResponse, err error) {
+	if f.CorporateBankingListFunc == nil {
+		err = &ErrNotFaked{Method: "CorporateBankingList"}
+		return
+	}
+	resp, err = f.CorporateBankingListFunc(ctx, req)
+	return
+}
+
+func (f *FakeCapitalService) ProvinceList(ctx context.Context) (resp *ProvinceListResponse, err error) {
+	if f.ProvinceListFunc == nil {
+		err = &ErrNotFaked{Method: "ProvinceList"}
+		return
+	}
+	resp, err = f.ProvinceListFunc(ctx)
+	return
+}
+
+func (f *FakeCapitalService) CityList(ctx context.Context, req CityListRequest) (resp *CityListResponse, err error) {
+	if f.CityListFunc == nil {
+		err = &ErrNotFaked{Method: "CityList"}
+		return
+	}
+	resp, err = f.CityListFunc(ctx, req)
+	return
+}
+
+func (f *FakeCapitalService) BankBranchList(ctx context.Context, req BankBranchListRequest) (resp *BankBranchListResponse, err error) {
+	if f.BankBranchListFunc == nil {
+		err = &ErrNotFaked{Method: "BankBranchList"}
+		return
+	}
+	resp, err = f.BankBranchListFunc(ctx, req)
+	return
+}
+
+// 图片上传服务的fake实现
+type FakeMediaService struct {
+	MediaUploadFunc func(context.Context, MediaUploadRequest) (*MediaUploadResponse, error)
+}
+
+func (f *FakeMediaService) MediaUpload(ctx context.Context, req MediaUploadRequest) (resp *MediaUploadResponse, err error) {
+	if f.MediaUploadFunc == nil {
+		err = &ErrNotFaked{Method: "MediaUpload"}
+		return
+	}
+	resp, err = f.MediaUploadFunc(ctx, req)
+	return
+}
+
+// 账单下载服务的fake实现
+type FakeBillService struct {
+	DownloadBillFunc func(context.Context, BillDownloadInfo) (io.ReadCloser, error)
+}
+
+func (f *FakeBillService) DownloadBill(ctx context.Context, info BillDownloadInfo) (body io.ReadCloser, err error) {
+	if f.DownloadBillFunc == nil {
+		err = &ErrNotFaked{Method: "DownloadBill"}
+		return
+	}
+	body, err = f.DownloadBillFunc(ctx, info)
+	return
+}
+
+// 平台证书服务的fake实现
+type FakeCertificateService struct {
+	GetCertificatesFunc func() (*GetCertificatesResp, error)
+}
+
+func (f *FakeCertificateService) GetCertificates() (resp *GetCertificatesResp, err error) {
+	if f.GetCertificatesFunc == nil {
+		err = &ErrNotFaked{Method: "GetCertificates"}
+		return
+	}
+	resp, err = f.GetCertificatesFunc()
+	return
+}
+
+var _ TransactionService = &FakeTransactionService{}
+var _ RefundService = &FakeRefundService{}
+var _ ProfitSharingService = &FakeProfitSharingService{}
+var _ TransferService = &FakeTransferService{}
+var _ FundService = &FakeFundService{}
+var _ ApplymentService = &FakeApplymentService{}
+var _ CapitalService = &FakeCapitalService{}
+var _ MediaService = &FakeMediaService{}
+var _ BillService = &FakeBillService{}
+var _ CertificateService = &FakeCertificateService{}
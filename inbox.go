@@ -0,0 +1,507 @@
+package wxmch_api
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	回调通知收件箱
+		验签后持久化原始通知和header，立即应答成功
+		由worker异步处理，失败按指数退避重试，超过最大次数进入死信
+		支持用GetResourcePlainText重新解密并重放已保存的通知
+*/
+
+// 收件箱消息状态
+type InboxStatus string
+
+// 待处理
+const InboxStatusPending InboxStatus = "PENDING"
+
+// 处理成功
+const InboxStatusSucceeded InboxStatus = "SUCCEEDED"
+
+// 超过最大重试次数，进入死信
+const InboxStatusDead InboxStatus = "DEAD"
+
+const defaultInboxWorkers = 4
+const defaultInboxMaxAttempts = 8
+const defaultInboxRetryInterval = 5 * time.Second
+
+var ErrInboxMessageBusy = errors.New("inbox消息正在处理")
+
+// 收件箱消息
+type InboxMessage struct {
+	// 通知ID
+	ID string `json:"id"`
+	// 通知报文
+	Notification Notification `json:"notification"`
+	// 原始header
+	Header http.Header `json:"header"`
+	// 原始报文
+	Body []byte `json:"body"`
+	// 接收时间
+	ReceivedAt time.Time `json:"received_at"`
+	// 状态
+	Status InboxStatus `json:"status"`
+	// 已处理次数
+	Attempts int `json:"attempts"`
+	// 最近一次处理失败原因
+	LastError string `json:"last_error"`
+	// 下一次处理时间
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// 收件箱存储
+type InboxStore interface {
+	// 保存新消息，相同ID的消息已存在时不覆盖，返回existed=true
+	Add(msg InboxMessage) (existed bool, err error)
+	// 获取消息，不存在时返回nil
+	Get(id string) (msg *InboxMessage, err error)
+	// 更新消息状态
+	Update(msg InboxMessage) (err error)
+	// 按状态列出消息，按接收时间排序
+	ListByStatus(status InboxStatus) (msgs []InboxMessage, err error)
+}
+
+type memoryInboxStore struct {
+	mu   sync.RWMutex
+	msgs map[string]InboxMessage
+}
+
+// 内存收件箱存储，仅用于测试或单机非持久场景，生产环境使用NewFileInboxStore或自行实现持久化的InboxStore
+func NewMemoryInboxStore() InboxStore {
+	return &memoryInboxStore{msgs: make(map[string]InboxMessage)}
+}
+
+func (s *memoryInboxStore) Add(msg InboxMessage) (existed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, existed = s.msgs[msg.ID]; existed {
+		return
+	}
+	s.msgs[msg.ID] = msg
+	return
+}
+
+func (s *memoryInboxStore) Get(id string) (msg *InboxMessage, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if m, ok := s.msgs[id]; ok {
+		msg = &m
+	}
+	return
+}
+
+func (s *memoryInboxStore) Update(msg InboxMessage) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.msgs[msg.ID]; !ok {
+		err = fmt.Errorf("inbox消息不存在:%s", msg.ID)
+		return
+	}
+	s.msgs[msg.ID] = msg
+	return
+}
+
+func (s *memoryInboxStore) ListByStatus(status InboxStatus) (msgs []InboxMessage, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, m := range s.msgs {
+		if m.Status == status {
+			msgs = append(msgs, m)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].ReceivedAt.Before(msgs[j].ReceivedAt)
+	})
+	return
+}
+
+// 文件收件箱存储，每条消息保存为目录下的一个json文件，打开时全部加载到内存
+// 写入先写临时文件再rename，进程崩溃不会留下写了一半的消息
+type FileInboxStore struct {
+	mu   sync.RWMutex
+	dir  string
+	msgs map[string]InboxMessage
+}
+
+// 打开文件收件箱存储，目录不存在时创建
+func NewFileInboxStore(dir string) (store *FileInboxStore, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	msgs := make(map[string]InboxMessage)
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		data, e := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if e != nil {
+			err = e
+			return
+		}
+		var msg InboxMessage
+		if e = json.Unmarshal(data, &msg); e != nil {
+			err = fmt.Errorf("inbox消息文件%s解析失败:%v", f.Name(), e)
+			return
+		}
+		msgs[msg.ID] = msg
+	}
+	store = &FileInboxStore{dir: dir, msgs: msgs}
+	return
+}
+
+func (s *FileInboxStore) Add(msg InboxMessage) (existed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, existed = s.msgs[msg.ID]; existed {
+		return
+	}
+	if err = s.write(msg); err != nil {
+		return
+	}
+	s.msgs[msg.ID] = msg
+	return
+}
+
+func (s *FileInboxStore) Get(id string) (msg *InboxMessage, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if m, ok := s.msgs[id]; ok {
+		msg = &m
+	}
+	return
+}
+
+func (s *FileInboxStore) Update(msg InboxMessage) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.msgs[msg.ID]; !ok {
+		err = fmt.Errorf("inbox消息不存在:%s", msg.ID)
+		return
+	}
+	if err = s.write(msg); err != nil {
+		return
+	}
+	s.msgs[msg.ID] = msg
+	return
+}
+
+func (s *FileInboxStore) ListByStatus(status InboxStatus) (msgs []InboxMessage, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, m := range s.msgs {
+		if m.Status == status {
+			msgs = append(msgs, m)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].ReceivedAt.Before(msgs[j].ReceivedAt)
+	})
+	return
+}
+
+// 写临时文件并fsync后rename到消息文件，文件名用通知ID的hex编码
+func (s *FileInboxStore) write(msg InboxMessage) (err error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(s.dir, ".inbox-*.tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), filepath.Join(s.dir, hex.EncodeToString([]byte(msg.ID))+".json"))
+	return
+}
+
+// 收件箱业务处理函数
+type InboxHandler func(ctx context.Context, n *Notification, plainText []byte) error
+
+type NotificationInbox struct {
+	client  MerchantApiClient
+	store   InboxStore
+	handler InboxHandler
+	// worker数量
+	Workers int
+	// 最大处理次数，超过后进入死信
+	MaxAttempts int
+	// 重试间隔，第n次重试等待RetryInterval*2^(n-1)
+	RetryInterval time.Duration
+
+	queue  chan string
+	mu     sync.Mutex
+	queued map[string]struct{}
+	// 正在处理的消息，同一条消息同时只能由一个worker或Replay处理
+	inflight map[string]struct{}
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewNotificationInbox(client MerchantApiClient, store InboxStore, handler InboxHandler) *NotificationInbox {
+	return &NotificationInbox{
+		client:        client,
+		store:         store,
+		handler:       handler,
+		Workers:       defaultInboxWorkers,
+		MaxAttempts:   defaultInboxMaxAttempts,
+		RetryInterval: defaultInboxRetryInterval,
+		queued:        make(map[string]struct{}),
+		inflight:      make(map[string]struct{}),
+	}
+}
+
+// 验签并保存通知，返回nil后即可应答微信支付成功
+func (ib *NotificationInbox) Receive(header http.Header, body []byte) (err error) {
+	n, err := ib.client.ParseNotification(header, body)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	existed, err := ib.store.Add(InboxMessage{
+		ID:            n.ID,
+		Notification:  *n,
+		Header:        header.Clone(),
+		Body:          body,
+		ReceivedAt:    now,
+		Status:        InboxStatusPending,
+		NextAttemptAt: now,
+	})
+	if err != nil || existed {
+		return
+	}
+	ib.enqueue(n.ID)
+	return
+}
+
+// 作为回调通知的http handler
+func (ib *NotificationInbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = ib.Receive(r.Header, body)
+	}
+	reply := struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{Code: "SUCCESS", Message: "成功"}
+	status := http.StatusOK
+	if err != nil {
+		reply.Code = "FAIL"
+		reply.Message = err.Error()
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(reply)
+}
+
+// 启动worker和重试扫描，启动时会加载存储中未处理的消息
+func (ib *NotificationInbox) Start(ctx context.Context) {
+	ctx, ib.cancel = context.WithCancel(ctx)
+	workers := ib.Workers
+	if workers <= 0 {
+		workers = defaultInboxWorkers
+	}
+	ib.queue = make(chan string, workers*16)
+	for i := 0; i < workers; i++ {
+		ib.wg.Add(1)
+		go ib.work(ctx)
+	}
+	ib.wg.Add(1)
+	go ib.scan(ctx)
+}
+
+// 停止处理，等待正在处理的消息结束
+func (ib *NotificationInbox) Stop() {
+	if ib.cancel != nil {
+		ib.cancel()
+	}
+	ib.wg.Wait()
+}
+
+// 死信列表
+func (ib *NotificationInbox) DeadLetters() (msgs []InboxMessage, err error) {
+	msgs, err = ib.store.ListByStatus(InboxStatusDead)
+	return
+}
+
+// 重放已保存的通知：重新解密资源并同步执行handler，不受最大处理次数限制，消息正在处理时返回ErrInboxMessageBusy
+func (ib *NotificationInbox) Replay(ctx context.Context, id string) (err error) {
+	msg, err := ib.store.Get(id)
+	if err != nil {
+		return
+	}
+	if msg == nil {
+		err = fmt.Errorf("inbox消息不存在:%s", id)
+		return
+	}
+	if !ib.claim(id) {
+		err = ErrInboxMessageBusy
+		return
+	}
+	defer ib.release(id)
+	// 认领后重新读取，避免覆盖worker刚保存的结果
+	msg, err = ib.store.Get(id)
+	if err != nil || msg == nil {
+		return
+	}
+	err = ib.process(ctx, *msg, true)
+	return
+}
+
+// 认领消息，消息正在处理时返回false
+func (ib *NotificationInbox) claim(id string) bool {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if _, ok := ib.inflight[id]; ok {
+		return false
+	}
+	ib.inflight[id] = struct{}{}
+	return true
+}
+
+// 处理结束后释放消息
+func (ib *NotificationInbox) release(id string) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	delete(ib.inflight, id)
+}
+
+func (ib *NotificationInbox) enqueue(id string) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if ib.queue == nil {
+		return
+	}
+	if _, ok := ib.queued[id]; ok {
+		return
+	}
+	if _, ok := ib.inflight[id]; ok {
+		return
+	}
+	// 队列满时不阻塞应答，由scan补偿
+	select {
+	case ib.queue <- id:
+		ib.queued[id] = struct{}{}
+	default:
+	}
+}
+
+func (ib *NotificationInbox) work(ctx context.Context) {
+	defer ib.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-ib.queue:
+			ib.mu.Lock()
+			delete(ib.queued, id)
+			ib.mu.Unlock()
+			// 正在处理的消息由scan在处理结束后重新入队
+			if !ib.claim(id) {
+				continue
+			}
+			msg, err := ib.store.Get(id)
+			if err == nil && msg != nil && msg.Status == InboxStatusPending {
+				_ = ib.process(ctx, *msg, false)
+			}
+			ib.release(id)
+		}
+	}
+}
+
+// 定期扫描到期的待处理消息
+func (ib *NotificationInbox) scan(ctx context.Context) {
+	defer ib.wg.Done()
+	interval := ib.RetryInterval
+	if interval <= 0 {
+		interval = defaultInboxRetryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		msgs, err := ib.store.ListByStatus(InboxStatusPending)
+		if err == nil {
+			now := time.Now()
+			for _, msg := range msgs {
+				if !msg.NextAttemptAt.After(now) {
+					ib.enqueue(msg.ID)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ib *NotificationInbox) process(ctx context.Context, msg InboxMessage, replay bool) (err error) {
+	plainText, err := ib.client.GetResourcePlainText(msg.Notification.Resource)
+	if err == nil {
+		n := msg.Notification
+		err = ib.handler(ctx, &n, plainText)
+	}
+	msg.Attempts++
+	if err == nil {
+		msg.Status = InboxStatusSucceeded
+		msg.LastError = ""
+		_ = ib.store.Update(msg)
+		return
+	}
+	msg.LastError = err.Error()
+	maxAttempts := ib.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultInboxMaxAttempts
+	}
+	switch {
+	case replay:
+		// 重放失败时保持原状态
+	case msg.Attempts >= maxAttempts:
+		msg.Status = InboxStatusDead
+	default:
+		interval := ib.RetryInterval
+		if interval <= 0 {
+			interval = defaultInboxRetryInterval
+		}
+		shift := msg.Attempts - 1
+		if shift > 10 {
+			shift = 10
+		}
+		msg.NextAttemptAt = time.Now().Add(interval << uint(shift))
+	}
+	_ = ib.store.Update(msg)
+	return
+}
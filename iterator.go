@@ -0,0 +1,39 @@
+package wxmch_api
+
+import (
+	"context"
+	"errors"
+)
+
+// 迭代器没有更多数据
+var ErrIteratorDone = errors.New("没有更多数据")
+
+// 按offset/limit分页查询，fetch返回本页数量和总数
+type offsetPager struct {
+	fetch   func(ctx context.Context, offset int, limit int) (n int, total int, err error)
+	limit   int
+	offset  int
+	total   int
+	started bool
+}
+
+// 拉取下一页，没有更多数据时返回ErrIteratorDone
+func (p *offsetPager) nextPage(ctx context.Context) (err error) {
+	if p.started && p.offset >= p.total {
+		err = ErrIteratorDone
+		return
+	}
+	n, total, err := p.fetch(ctx, p.offset, p.limit)
+	if err != nil {
+		return
+	}
+	p.started = true
+	p.total = total
+	p.offset += n
+	if n == 0 {
+		// 总数不准确时避免死循环
+		p.total = p.offset
+		err = ErrIteratorDone
+	}
+	return
+}
@@ -1,7 +1,9 @@
 package wxmch_api
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 )
 
 /*
@@ -27,6 +29,16 @@ const EVENTTYPE_REFUND_CLOSED EventTypeEnum = "REFUND.CLOSED"
 // 分账回退
 const EVENTTYPE_TRANSACTION_RETURN EventTypeEnum = "TRANSACTION.RETURN"
 
+// 分账动账通知的类型
+// 分账成功，与支付成功通知的类型相同，解密后的数据中有order_id
+const EVENTTYPE_PROFITSHARING_SUCCESS EventTypeEnum = "TRANSACTION.SUCCESS"
+
+// 分账回退成功
+const EVENTTYPE_PROFITSHARING_RETURN EventTypeEnum = EVENTTYPE_TRANSACTION_RETURN
+
+// 分账动账通知的所有类型
+var ProfitSharingEventTypes = []EventTypeEnum{EVENTTYPE_PROFITSHARING_SUCCESS, EVENTTYPE_PROFITSHARING_RETURN}
+
 // 通知报文
 type Notification struct {
 	// 通知ID
@@ -55,10 +67,21 @@ type CipherBlockResource struct {
 	Nonce string `json:"nonce"`
 }
 
+// 验证回调通知的签名并解析通知报文
+// 平台证书模式、微信支付公钥模式和混合模式都根据header中的Wechatpay-Serial选择验签公钥
+func (c MerchantApiClient) ParseNotification(header http.Header, body []byte) (n *Notification, err error) {
+	err = c.verifyWechatSignature(header, body)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(body, &n)
+	return
+}
+
 func (c MerchantApiClient) GetResourcePlainText(r CipherBlockResource) (plainText []byte, err error) {
 	switch r.Algorithm {
 	case "AEAD_AES_256_GCM":
-		plainText = decryptCiphertextWithGCM(r.AssociatedData, r.Nonce, r.Ciphertext, c.apiSecret)
+		plainText, err = decryptCiphertextWithGCM(r.AssociatedData, r.Nonce, r.Ciphertext, c.apiSecret)
 	default:
 		err = fmt.Errorf("algorithm:%s not supported", r.Algorithm)
 		return
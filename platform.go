@@ -1,12 +1,69 @@
 package wxmch_api
 
-import "crypto/rsa"
+import (
+	"crypto/rsa"
+	"strings"
+)
 
 /*
 	平台证书
- */
+	微信支付公钥
+*/
 
 type PlatformCertificatesMap interface {
 	GetPublicKey(serialNo string) (pubKey *rsa.PublicKey)
 }
 
+// 微信支付公钥ID前缀
+const PublicKeyIDPrefix = "PUB_KEY_ID_"
+
+// 判断Wechatpay-Serial是否为微信支付公钥ID
+func IsPublicKeyID(serialNo string) bool {
+	return strings.HasPrefix(serialNo, PublicKeyIDPrefix)
+}
+
+// 微信支付公钥模式，只认微信支付公钥ID
+type publicKeyMap struct {
+	// 微信支付公钥ID
+	keyID string
+	// 微信支付公钥
+	pubKey *rsa.PublicKey
+}
+
+// 创建微信支付公钥模式的PlatformCertificatesMap
+// 创建客户端时platformNo传入公钥ID，敏感信息加密和验签都使用微信支付公钥
+func NewPublicKeyMap(keyID string, pubKey *rsa.PublicKey) PlatformCertificatesMap {
+	return publicKeyMap{keyID: keyID, pubKey: pubKey}
+}
+
+func (m publicKeyMap) GetPublicKey(serialNo string) (pubKey *rsa.PublicKey) {
+	if serialNo == m.keyID {
+		pubKey = m.pubKey
+	}
+	return
+}
+
+// 混合模式，平台证书和微信支付公钥同时可用于验签，用于平台证书向微信支付公钥迁移期间
+type hybridCertificatesMap struct {
+	certMap PlatformCertificatesMap
+	publicKeyMap
+}
+
+// 创建混合模式的PlatformCertificatesMap
+// 加密使用哪一个由创建客户端时的platformNo决定：传入公钥ID使用微信支付公钥，传入证书序列号使用平台证书
+func NewHybridCertificatesMap(certMap PlatformCertificatesMap, keyID string, pubKey *rsa.PublicKey) PlatformCertificatesMap {
+	return hybridCertificatesMap{
+		certMap:      certMap,
+		publicKeyMap: publicKeyMap{keyID: keyID, pubKey: pubKey},
+	}
+}
+
+func (m hybridCertificatesMap) GetPublicKey(serialNo string) (pubKey *rsa.PublicKey) {
+	if IsPublicKeyID(serialNo) {
+		return m.publicKeyMap.GetPublicKey(serialNo)
+	}
+	if m.certMap == nil {
+		return
+	}
+	return m.certMap.GetPublicKey(serialNo)
+}
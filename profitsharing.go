@@ -34,6 +34,16 @@ type ProfitShareApplyRequest struct {
 	Finish bool `json:"finish"`
 }
 
+// 单次分账最多的接收方数量
+const MaxProfitShareReceivers = 50
+
+// 分账接收方类型
+// 商户
+const ReceiverTypeMerchant = "MERCHANT_ID"
+
+// 个人openid
+const ReceiverTypePersonalOpenID = "PERSONAL_OPENID"
+
 type ReceiverInProfitShareRequest struct {
 	// 分账接收方类型
 	Type string `json:"type"`
@@ -61,7 +71,9 @@ type ProfitShareApplyResponse struct {
 // 请求分账API
 func (c MerchantApiClient) ProfitShareApply(ctx context.Context, req ProfitShareApplyRequest) (resp *ProfitShareApplyResponse, err error) {
 	url := "/v3/ecommerce/profitsharing/orders"
-	rcvs := req.Receivers
+	// 复制接收方列表，避免加密后修改调用方的请求
+	rcvs := append([]ReceiverInProfitShareRequest(nil), req.Receivers...)
+	req.Receivers = rcvs
 	pubKey := c.getPlatformPublicKey()
 	for i := range rcvs {
 		// 姓名需要加密
@@ -105,9 +117,26 @@ type ProfitShareQueryResponse struct {
 	// 分账完结金额
 	FinishAmount uint `json:"finish_amount"`
 	// 分账完结描述
-	FinishDescription uint `json:"finish_description"`
+	FinishDescription string `json:"finish_description"`
 }
 
+// 分账单状态
+// 处理中
+const ProfitShareStatusProcessing = "PROCESSING"
+
+// 处理完成
+const ProfitShareStatusFinished = "FINISHED"
+
+// 分账接收方的分账结果
+// 待分账
+const ProfitShareResultPending = "PENDING"
+
+// 分账成功
+const ProfitShareResultSuccess = "SUCCESS"
+
+// 已关闭
+const ProfitShareResultClosed = "CLOSED"
+
 type ReceiverInProfitShareResponse struct {
 	// 分账接收商户号
 	ReceiverMchID string `json:"receiver_mchid"`
@@ -185,6 +214,16 @@ type ProfitReturnApplyResponse struct {
 	FinishTime string `json:"finish_time"`
 }
 
+// 分账回退结果
+// 处理中
+const ProfitReturnResultProcessing = "PROCESSING"
+
+// 已成功
+const ProfitReturnResultSuccess = "SUCCESS"
+
+// 已失败
+const ProfitReturnResultFailed = "FAILED"
+
 // 请求分账回退API
 func (c MerchantApiClient) ProfitReturnApply(ctx context.Context, req ProfitReturnApplyRequest) (resp *ProfitReturnApplyResponse, err error) {
 	url := "/v3/ecommerce/profitsharing/returnorders"
@@ -351,10 +390,12 @@ type ReceiversAddResponse struct {
 func (c MerchantApiClient) ReceiversAdd(ctx context.Context, req ReceiversAddRequest) (resp *ReceiversAddResponse, err error) {
 	url := "/v3/ecommerce/profitsharing/receivers/add"
 	switch req.Type {
-	case "PERSONAL_OPENID":
+	case ReceiverTypePersonalOpenID:
 		// 分账接收方为个人，名字需要加密
-		pubKey := c.getPlatformPublicKey()
-		req.EncryptedName = encryptCiphertext(req.EncryptedName, pubKey)
+		if req.EncryptedName != "" {
+			pubKey := c.getPlatformPublicKey()
+			req.EncryptedName = encryptCiphertext(req.EncryptedName, pubKey)
+		}
 	}
 
 	body, _ := json.Marshal(&req)
@@ -0,0 +1,351 @@
+package wxmch_api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	分账账单
+	申请分账账单后下载并逐行解析，不把整个账单读入内存；读完后校验摘要，并按接收方汇总金额
+	普通服务商使用ProfitShareBill，电商平台使用EcommerceProfitShareBill，查询二级商户的分账账单时填写SubMchID
+*/
+
+type ProfitShareBillRequest struct {
+	// 二级商户号，为空时查询平台自身的分账账单
+	SubMchID string `validate:"max=32"`
+	// 账单日期，格式yyyy-MM-DD
+	BillDate string `validate:"required"`
+	// 压缩类型，为空时不压缩，GZIP：返回gzip压缩的账单
+	TarType string
+}
+
+// 申请分账账单API
+func (c MerchantApiClient) ProfitShareBill(ctx context.Context, req ProfitShareBillRequest) (resp *BillDownloadInfo, err error) {
+	resp, err = c.profitShareBill(ctx, "/v3/profitsharing/bills", req)
+	return
+}
+
+// 电商平台申请分账账单API
+func (c MerchantApiClient) EcommerceProfitShareBill(ctx context.Context, req ProfitShareBillRequest) (resp *BillDownloadInfo, err error) {
+	resp, err = c.profitShareBill(ctx, "/v3/ecommerce/profitsharing/bills", req)
+	return
+}
+
+func (c MerchantApiClient) profitShareBill(ctx context.Context, url string, req ProfitShareBillRequest) (resp *BillDownloadInfo, err error) {
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	qm := map[string]string{"bill_date": req.BillDate}
+	if req.SubMchID != "" {
+		qm["sub_mchid"] = req.SubMchID
+	}
+	if req.TarType != "" {
+		qm["tar_type"] = req.TarType
+	}
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", url, qm, nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	if err != nil {
+		return
+	}
+	resp.TarType = req.TarType
+	return
+}
+
+// 申请并下载分账账单，返回逐行读取的迭代器，用完后需要Close
+func (c MerchantApiClient) DownloadProfitShareBill(ctx context.Context, req ProfitShareBillRequest) (it *ProfitShareBillIterator, err error) {
+	info, err := c.ProfitShareBill(ctx, req)
+	if err != nil {
+		return
+	}
+	it, err = c.downloadProfitShareBill(ctx, info)
+	return
+}
+
+// 电商平台申请并下载分账账单，返回逐行读取的迭代器，用完后需要Close
+func (c MerchantApiClient) DownloadEcommerceProfitShareBill(ctx context.Context, req ProfitShareBillRequest) (it *ProfitShareBillIterator, err error) {
+	info, err := c.EcommerceProfitShareBill(ctx, req)
+	if err != nil {
+		return
+	}
+	it, err = c.downloadProfitShareBill(ctx, info)
+	return
+}
+
+func (c MerchantApiClient) downloadProfitShareBill(ctx context.Context, info *BillDownloadInfo) (it *ProfitShareBillIterator, err error) {
+	body, err := c.DownloadBill(ctx, *info)
+	if err != nil {
+		return
+	}
+	it = NewProfitShareBillIterator(body)
+	return
+}
+
+// 分账账单的一行
+type ProfitShareBillRow struct {
+	// 分账发起时间
+	Time time.Time
+	// 分账方商户号
+	MchID string
+	// 微信订单号
+	TransactionID string
+	// 微信分账/回退单号
+	OrderID string
+	// 商户分账/回退单号
+	OutOrderNo string
+	// 业务类型，分账或回退
+	BusinessType string
+	// 分账接收方类型
+	ReceiverType string
+	// 分账接收方
+	Receiver string
+	// 分账/回退金额（分）
+	Amount int64
+	// 处理状态
+	Status string
+	// 分账/回退描述
+	Description string
+	// 原始字段
+	Fields []string
+}
+
+// 是否是分账回退
+func (r ProfitShareBillRow) IsReturn() bool {
+	return strings.Contains(r.BusinessType, "回退") || strings.EqualFold(r.BusinessType, "RETURN")
+}
+
+// 是否是失败或关闭的分账，汇总时不计入
+func (r ProfitShareBillRow) IsFailed() bool {
+	status := strings.ToUpper(r.Status)
+	return strings.Contains(r.Status, "失败") || strings.Contains(r.Status, "关闭") || status == "FAILED" || status == "CLOSED"
+}
+
+// 一个接收方的汇总
+type ProfitShareBillTotal struct {
+	// 分账接收方
+	Receiver string
+	// 分账金额（分）
+	SharedAmount int64
+	// 回退金额（分）
+	ReturnedAmount int64
+	// 净分账金额（分）
+	NetAmount int64
+	// 账单行数
+	Count int
+}
+
+// 账单各列的表头，与分账账单的文档格式一致，缺少任意一列时按无法识别的表头处理
+var profitShareBillColumns = map[string]string{
+	"time":           "分账发起时间",
+	"mch_id":         "分账方商户号",
+	"transaction_id": "微信订单号",
+	"order_id":       "微信分账/回退单号",
+	"out_order_no":   "商户分账/回退单号",
+	"receiver_type":  "分账接收方类型",
+	"receiver":       "分账接收方",
+	"amount":         "分账金额(元)",
+	"business_type":  "业务类型",
+	"status":         "处理状态",
+	"description":    "分账描述",
+}
+
+// 分账账单迭代器
+type ProfitShareBillIterator struct {
+	body    io.Closer
+	reader  *csv.Reader
+	columns map[string]int
+	totals  map[string]*ProfitShareBillTotal
+	done    bool
+	// 表头无法识别时后续都返回该错误
+	headerErr error
+}
+
+// 从账单内容创建迭代器，body为DownloadBill的返回值时读完后会校验摘要
+func NewProfitShareBillIterator(body io.Reader) *ProfitShareBillIterator {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	it := &ProfitShareBillIterator{reader: reader, totals: make(map[string]*ProfitShareBillTotal)}
+	if closer, ok := body.(io.Closer); ok {
+		it.body = closer
+	}
+	return it
+}
+
+// 下一行，读完且摘要校验通过时返回ErrIteratorDone
+func (it *ProfitShareBillIterator) Next() (row *ProfitShareBillRow, err error) {
+	if it.done {
+		err = ErrIteratorDone
+		return
+	}
+	if it.headerErr != nil {
+		err = it.headerErr
+		return
+	}
+	if it.columns == nil {
+		err = it.readHeader()
+		if err != nil {
+			it.headerErr = err
+			return
+		}
+	}
+	fields, err := it.reader.Read()
+	if err == nil && (len(fields) == 0 || !strings.HasPrefix(strings.TrimSpace(fields[0]), "`")) {
+		// 明细之后是汇总，读完剩余内容以便校验摘要
+		for err == nil {
+			_, err = it.reader.Read()
+		}
+	}
+	if err == io.EOF {
+		it.done = true
+		err = ErrIteratorDone
+		return
+	}
+	if err != nil {
+		return
+	}
+	row, err = it.parseRow(fields)
+	if err != nil {
+		return
+	}
+	it.addTotal(row)
+	return
+}
+
+func (it *ProfitShareBillIterator) readHeader() (err error) {
+	header, err := it.reader.Read()
+	if err == io.EOF {
+		err = errors.New("分账账单为空")
+		return
+	}
+	if err != nil {
+		return
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[string(trimBOM([]byte(trimBillField(name))))] = i
+	}
+	columns := make(map[string]int, len(profitShareBillColumns))
+	var missing []string
+	for key, name := range profitShareBillColumns {
+		i, ok := index[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		columns[key] = i
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		err = fmt.Errorf("无法识别的分账账单表头:%s，缺少%s", strings.Join(header, ","), strings.Join(missing, ","))
+		return
+	}
+	it.columns = columns
+	return
+}
+
+func (it *ProfitShareBillIterator) field(fields []string, key string) string {
+	i := it.columns[key]
+	if i >= len(fields) {
+		return ""
+	}
+	return trimBillField(fields[i])
+}
+
+func (it *ProfitShareBillIterator) parseRow(fields []string) (row *ProfitShareBillRow, err error) {
+	row = &ProfitShareBillRow{
+		MchID:         it.field(fields, "mch_id"),
+		TransactionID: it.field(fields, "transaction_id"),
+		OrderID:       it.field(fields, "order_id"),
+		OutOrderNo:    it.field(fields, "out_order_no"),
+		BusinessType:  it.field(fields, "business_type"),
+		ReceiverType:  it.field(fields, "receiver_type"),
+		Receiver:      it.field(fields, "receiver"),
+		Status:        it.field(fields, "status"),
+		Description:   it.field(fields, "description"),
+		Fields:        fields,
+	}
+	row.Amount, err = parseBillYuan(it.field(fields, "amount"))
+	if err != nil {
+		err = fmt.Errorf("分账账单金额格式错误:%v", err)
+		row = nil
+		return
+	}
+	if t := it.field(fields, "time"); t != "" {
+		row.Time, err = parseBillTime(t)
+		if err != nil {
+			err = fmt.Errorf("分账账单时间格式错误:%v", err)
+			row = nil
+			return
+		}
+	}
+	return
+}
+
+func (it *ProfitShareBillIterator) addTotal(row *ProfitShareBillRow) {
+	if row.IsFailed() {
+		return
+	}
+	total, ok := it.totals[row.Receiver]
+	if !ok {
+		total = &ProfitShareBillTotal{Receiver: row.Receiver}
+		it.totals[row.Receiver] = total
+	}
+	amount := row.Amount
+	if amount < 0 {
+		amount = -amount
+	}
+	if row.IsReturn() {
+		total.ReturnedAmount += amount
+		total.NetAmount -= amount
+	} else {
+		total.SharedAmount += amount
+		total.NetAmount += amount
+	}
+	total.Count++
+}
+
+// 已读取的账单行按接收方汇总，不包括失败或关闭的分账，读完后即为整个账单的汇总
+func (it *ProfitShareBillIterator) Totals() (totals []ProfitShareBillTotal) {
+	for _, total := range it.totals {
+		totals = append(totals, *total)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		return totals[i].Receiver < totals[j].Receiver
+	})
+	return
+}
+
+// 读完剩余的账单行并返回按接收方的汇总
+func (it *ProfitShareBillIterator) ReadAllTotals() (totals []ProfitShareBillTotal, err error) {
+	for {
+		_, err = it.Next()
+		if err == ErrIteratorDone {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+	}
+	totals = it.Totals()
+	return
+}
+
+func (it *ProfitShareBillIterator) Close() error {
+	if it.body == nil {
+		return nil
+	}
+	return it.body.Close()
+}
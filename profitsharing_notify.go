@@ -0,0 +1,210 @@
+package wxmch_api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	通知的类型化解码和分账动账通知的关联
+	分账成功通知与支付成功通知的event_type相同，按解密后的数据中是否有order_id区分；
+	分账回退通知的out_order_no是商户回退单号
+*/
+
+// 通知的业务主键，用于区分同类型的通知
+type notificationKeys struct {
+	OrderID string `json:"order_id"`
+}
+
+func decodeNotificationKeys(plainText []byte) (keys notificationKeys, err error) {
+	err = json.Unmarshal(plainText, &keys)
+	return
+}
+
+// 解码支付成功通知
+func DecodePayNotification(n *Notification, plainText []byte) (pay *PayNotification, err error) {
+	if EventTypeEnum(n.EventType) != EVENTTYPE_TRANSACTION_SUCCESS {
+		err = fmt.Errorf("通知类型%s不是支付成功通知", n.EventType)
+		return
+	}
+	keys, err := decodeNotificationKeys(plainText)
+	if err != nil {
+		return
+	}
+	if keys.OrderID != "" {
+		err = fmt.Errorf("通知%s是分账通知", n.ID)
+		return
+	}
+	err = json.Unmarshal(plainText, &pay)
+	return
+}
+
+// 解码退款通知（退款成功、退款异常、退款关闭）
+func DecodeRefundNotification(n *Notification, plainText []byte) (refund *RefundNotification, err error) {
+	switch EventTypeEnum(n.EventType) {
+	case EVENTTYPE_REFUND_SUCCESS, EVENTTYPE_REFUND_ABNORMAL, EVENTTYPE_REFUND_CLOSED:
+	default:
+		err = fmt.Errorf("通知类型%s不是退款通知", n.EventType)
+		return
+	}
+	err = json.Unmarshal(plainText, &refund)
+	return
+}
+
+// 分账动账事件
+type ProfitSharingEvent struct {
+	// 通知ID
+	ID string
+	// 通知类型
+	EventType EventTypeEnum
+	ProfitSharingNotification
+}
+
+// 是否是分账回退，回退时OutOrderNo是商户回退单号
+func (e *ProfitSharingEvent) IsReturn() bool {
+	return e.EventType == EVENTTYPE_PROFITSHARING_RETURN
+}
+
+// 是否是ProfitShareApply请求的分账
+func (e *ProfitSharingEvent) MatchesApply(req ProfitShareApplyRequest) bool {
+	if e.IsReturn() || e.OutOrderNo != req.OutOrderNo {
+		return false
+	}
+	return req.TransactionID == "" || e.TransactionID == "" || req.TransactionID == e.TransactionID
+}
+
+// 是否是ProfitReturnApply请求的分账回退
+func (e *ProfitSharingEvent) MatchesReturn(req ProfitReturnApplyRequest) bool {
+	return e.IsReturn() && e.OutOrderNo == req.OutReturnNo
+}
+
+// 是否是分账动账通知
+func IsProfitSharingNotification(n *Notification, plainText []byte) bool {
+	switch EventTypeEnum(n.EventType) {
+	case EVENTTYPE_PROFITSHARING_RETURN:
+		return true
+	case EVENTTYPE_PROFITSHARING_SUCCESS:
+		keys, err := decodeNotificationKeys(plainText)
+		return err == nil && keys.OrderID != ""
+	}
+	return false
+}
+
+// 解码分账动账通知
+func DecodeProfitSharingNotification(n *Notification, plainText []byte) (event *ProfitSharingEvent, err error) {
+	if !IsProfitSharingNotification(n, plainText) {
+		err = fmt.Errorf("通知%s不是分账动账通知", n.ID)
+		return
+	}
+	event = &ProfitSharingEvent{ID: n.ID, EventType: EventTypeEnum(n.EventType)}
+	err = json.Unmarshal(plainText, &event.ProfitSharingNotification)
+	if err != nil {
+		event = nil
+	}
+	return
+}
+
+// 用分账成功通知更新对应的分账单，没有对应的分账单时matched=false
+// 通知中的接收方标记为分账成功，所有接收方都成功后分账单处理完成，满足条件时自动完结
+func (w *ProfitShareWorkflow) HandleNotification(ctx context.Context, event *ProfitSharingEvent) (matched bool, err error) {
+	if event.IsReturn() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if event.TransactionID != "" && event.TransactionID != w.state.TransactionID {
+		return
+	}
+	s := w.findSplit(event.OutOrderNo)
+	if s == nil {
+		return
+	}
+	matched = true
+	if s.Status == "" {
+		// 请求分账超时但实际已经受理
+		s.Status = ProfitShareStatusProcessing
+		s.Error = ""
+	}
+	s.OrderID = event.OrderID
+	results := make(map[string]ReceiverInProfitShareResponse, len(s.Results))
+	for _, r := range s.Results {
+		results[r.Type+"/"+r.Account] = r
+	}
+	for _, r := range event.Receivers {
+		results[r.Type+"/"+r.Account] = ReceiverInProfitShareResponse{
+			Type:        r.Type,
+			Account:     r.Account,
+			Amount:      r.Amount,
+			Description: r.Description,
+			Result:      ProfitShareResultSuccess,
+			FinishTime:  event.SuccessTime,
+		}
+	}
+	s.Results = nil
+	finished := true
+	for _, r := range s.Receivers {
+		result, ok := results[r.Type+"/"+r.Account]
+		if !ok {
+			result = ReceiverInProfitShareResponse{Type: r.Type, Account: r.Account, Amount: r.Amount, Description: r.Description, Result: ProfitShareResultPending}
+		}
+		finished = finished && result.Result != ProfitShareResultPending
+		s.Results = append(s.Results, result)
+	}
+	if finished {
+		s.Status = ProfitShareStatusFinished
+	}
+	s.UpdatedAt = time.Now()
+
+	if description, ok := w.finishDescription(); ok {
+		err = w.finish(ctx, description)
+	}
+	if saveErr := w.save(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return
+}
+
+// 由商户回退单号得到退款流程的商户退款单号
+func RefundSagaOutRefundNo(outReturnNo string) (outRefundNo string, ok bool) {
+	i := strings.LastIndex(outReturnNo, "_")
+	if i <= 0 {
+		return
+	}
+	outRefundNo, ok = outReturnNo[:i], true
+	return
+}
+
+// 用分账回退通知把对应的回退步骤标记为成功，没有对应的回退步骤时matched=false
+func (s *RefundSaga) HandleNotification(event *ProfitSharingEvent) (matched bool, err error) {
+	if !event.IsReturn() {
+		return
+	}
+	outRefundNo, ok := RefundSagaOutRefundNo(event.OutOrderNo)
+	if !ok {
+		return
+	}
+	state, err := s.store.Load(outRefundNo)
+	if err != nil || state == nil {
+		return
+	}
+	for i := range state.Returns {
+		step := &state.Returns[i]
+		if step.OutReturnNo != event.OutOrderNo {
+			continue
+		}
+		matched = true
+		if step.Result == ProfitReturnResultSuccess {
+			return
+		}
+		step.ReturnNo = event.OrderID
+		step.Result = ProfitReturnResultSuccess
+		step.Error = ""
+		step.UpdatedAt = time.Now()
+		err = s.save(state)
+		return
+	}
+	return
+}
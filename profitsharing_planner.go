@@ -0,0 +1,201 @@
+package wxmch_api
+
+import "fmt"
+
+/*
+	分账金额计划
+	按固定金额、订单金额比例计算每个接收方的分账金额，检查剩余待分金额、平台最大分账比例和接收方数量，
+	不合法时返回ValidationErrors，Field指向违反的规则，例如/rules/1/ratio
+*/
+
+// 比例的分母，比例按万分之一计
+const ProfitShareRatioBase = 10000
+
+// 电商平台默认的最大分账比例 30%
+const DefaultMaxProfitShareRatio = 3000
+
+// 按比例计算金额时不足1分的处理方式
+type ProfitShareRounding string
+
+// 舍去
+const ProfitShareRoundDown ProfitShareRounding = "ROUND_DOWN"
+
+// 四舍五入
+const ProfitShareRoundHalfUp ProfitShareRounding = "ROUND_HALF_UP"
+
+// 进位
+const ProfitShareRoundUp ProfitShareRounding = "ROUND_UP"
+
+// 一个接收方的分账规则，FixedAmount和Ratio只能填写一个
+type ProfitShareRule struct {
+	// 分账接收方类型
+	Type string `json:"type"`
+	// 分账接收方帐号
+	Account string `json:"receiver_account"`
+	// 分账接收方姓名
+	ReceiverName string `json:"receiver_name,omitempty"`
+	// 分账描述
+	Description string `json:"description"`
+	// 固定分账金额（分）
+	FixedAmount uint `json:"fixed_amount,omitempty"`
+	// 按订单金额的分账比例（万分之一）
+	Ratio uint `json:"ratio,omitempty"`
+	// 是否接收按比例计算后舍去的零头，最多一个接收方
+	TakesRemainder bool `json:"takes_remainder,omitempty"`
+}
+
+type ProfitSharePlanRequest struct {
+	// 订单金额
+	OrderTotal uint `json:"order_total"`
+	// 剩余待分金额，ProfitShareUnSplitAmountQuery的结果
+	UnSplitAmount uint `json:"unsplit_amount"`
+	// 之前的分账已经分出的金额，计入最大分账比例
+	SharedAmount uint `json:"shared_amount"`
+	// 分账规则
+	Rules []ProfitShareRule `json:"rules"`
+}
+
+// 分账计划
+type ProfitSharePlan struct {
+	// 分账接收方列表，与规则一一对应
+	Receivers []ReceiverInProfitShareRequest
+	// 本次分账总金额
+	TotalAmount uint
+	// 本次分账后剩余待分金额
+	RemainingAmount uint
+}
+
+// 生成请求分账参数
+func (p *ProfitSharePlan) ApplyRequest(subMchID string, transactionID string, outOrderNo string) ProfitShareApplyRequest {
+	return ProfitShareApplyRequest{
+		SubMchID:      subMchID,
+		TransactionID: transactionID,
+		OutOrderNo:    outOrderNo,
+		Receivers:     append([]ReceiverInProfitShareRequest(nil), p.Receivers...),
+	}
+}
+
+type ProfitSharePlanner struct {
+	// 平台最大分账比例（万分之一），为0时使用DefaultMaxProfitShareRatio
+	MaxRatio uint
+	// 为空时舍去
+	Rounding ProfitShareRounding
+}
+
+// 按规则计算分账计划
+func (p ProfitSharePlanner) Plan(req ProfitSharePlanRequest) (plan *ProfitSharePlan, err error) {
+	var errs ValidationErrors
+	maxRatio := p.MaxRatio
+	if maxRatio == 0 {
+		maxRatio = DefaultMaxProfitShareRatio
+	}
+	if req.OrderTotal == 0 {
+		errs.add("/order_total", nil, "不能为空")
+	}
+	if len(req.Rules) == 0 {
+		errs.add("/rules", nil, "不能为空")
+	}
+	if len(req.Rules) > MaxProfitShareReceivers {
+		errs.add("/rules", len(req.Rules), "接收方数量不能大于%d", MaxProfitShareReceivers)
+	}
+
+	owner := -1
+	var ratioSum uint64
+	seen := make(map[string]int)
+	for i, rule := range req.Rules {
+		path := fmt.Sprintf("/rules/%d", i)
+		switch rule.Type {
+		case ReceiverTypeMerchant, ReceiverTypePersonalOpenID:
+		default:
+			errs.add(path+"/type", rule.Type, "接收方类型应为%s或%s", ReceiverTypeMerchant, ReceiverTypePersonalOpenID)
+		}
+		requireString(&errs, path+"/receiver_account", rule.Account)
+		requireString(&errs, path+"/description", rule.Description)
+		if j, ok := seen[rule.Type+"/"+rule.Account]; ok {
+			errs.add(path+"/receiver_account", rule.Account, "与/rules/%d重复", j)
+		} else {
+			seen[rule.Type+"/"+rule.Account] = i
+		}
+		switch {
+		case rule.FixedAmount != 0 && rule.Ratio != 0:
+			errs.add(path, nil, "固定金额和分账比例只能填写一个")
+		case rule.FixedAmount == 0 && rule.Ratio == 0 && !rule.TakesRemainder:
+			errs.add(path, nil, "固定金额和分账比例不能同时为空")
+		case rule.Ratio > ProfitShareRatioBase:
+			errs.add(path+"/ratio", rule.Ratio, "分账比例不能大于%d", ProfitShareRatioBase)
+		}
+		ratioSum += uint64(rule.Ratio)
+		if rule.TakesRemainder {
+			if owner >= 0 {
+				errs.add(path+"/takes_remainder", true, "/rules/%d已经接收零头，只能有一个接收方接收零头", owner)
+			} else {
+				owner = i
+			}
+		}
+	}
+	if len(errs) > 0 {
+		err = errs
+		return
+	}
+
+	// 有零头接收方时每个比例金额舍去，零头按整体比例计算后补给接收方
+	rounding := p.Rounding
+	if owner >= 0 {
+		rounding = ProfitShareRoundDown
+	}
+	plan = &ProfitSharePlan{}
+	var total, ratioTotal uint64
+	for _, rule := range req.Rules {
+		amount := uint64(rule.FixedAmount)
+		if rule.Ratio != 0 {
+			amount = roundRatio(uint64(req.OrderTotal), uint64(rule.Ratio), rounding)
+			ratioTotal += amount
+		}
+		plan.Receivers = append(plan.Receivers, ReceiverInProfitShareRequest{
+			Type:         rule.Type,
+			Account:      rule.Account,
+			Amount:       uint(amount),
+			Description:  rule.Description,
+			ReceiverName: rule.ReceiverName,
+		})
+		total += amount
+	}
+	if owner >= 0 {
+		remainder := roundRatio(uint64(req.OrderTotal), ratioSum, p.Rounding) - ratioTotal
+		plan.Receivers[owner].Amount += uint(remainder)
+		total += remainder
+	}
+
+	for i, r := range plan.Receivers {
+		if r.Amount == 0 {
+			errs.add(fmt.Sprintf("/rules/%d", i), nil, "计算后的分账金额为0")
+		}
+	}
+	if total > uint64(req.UnSplitAmount) {
+		errs.add("/unsplit_amount", req.UnSplitAmount, "分账总金额%d超过剩余待分金额%d", total, req.UnSplitAmount)
+	}
+	maxAmount := uint64(req.OrderTotal) * uint64(maxRatio) / ProfitShareRatioBase
+	if total+uint64(req.SharedAmount) > maxAmount {
+		errs.add("/rules", nil, "累计分账金额%d超过平台最大分账比例%d/%d对应的金额%d", total+uint64(req.SharedAmount), maxRatio, ProfitShareRatioBase, maxAmount)
+	}
+	if len(errs) > 0 {
+		plan = nil
+		err = errs
+		return
+	}
+	plan.TotalAmount = uint(total)
+	plan.RemainingAmount = req.UnSplitAmount - uint(total)
+	return
+}
+
+// amount*ratio/ProfitShareRatioBase，按rounding处理不足1分的部分
+func roundRatio(amount uint64, ratio uint64, rounding ProfitShareRounding) uint64 {
+	n := amount * ratio
+	switch rounding {
+	case ProfitShareRoundHalfUp:
+		return (n + ProfitShareRatioBase/2) / ProfitShareRatioBase
+	case ProfitShareRoundUp:
+		return (n + ProfitShareRatioBase - 1) / ProfitShareRatioBase
+	}
+	return n / ProfitShareRatioBase
+}
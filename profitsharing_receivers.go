@@ -0,0 +1,280 @@
+package wxmch_api
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+/*
+	分账接收方管理
+	微信支付没有查询分账接收方列表的接口，已添加的接收方保存在本地登记簿中。
+	按配置的接收方列表与登记簿比较，添加新的或信息变化的接收方，删除不再需要的接收方，返回每个接收方的处理结果
+*/
+
+// 与分账方的关系类型
+// 供应商
+const ReceiverRelationSupplier = "SUPPLIER"
+
+// 分销商
+const ReceiverRelationDistributor = "DISTRIBUTOR"
+
+// 服务商
+const ReceiverRelationServiceProvider = "SERVICE_PROVIDER"
+
+// 平台
+const ReceiverRelationPlatform = "PLATFORM"
+
+// 其他
+const ReceiverRelationOthers = "OTHERS"
+
+func isReceiverRelationType(relationType string) bool {
+	switch relationType {
+	case ReceiverRelationSupplier, ReceiverRelationDistributor, ReceiverRelationServiceProvider, ReceiverRelationPlatform, ReceiverRelationOthers:
+		return true
+	}
+	return false
+}
+
+// 分账接收方
+type ProfitShareReceiver struct {
+	// 接收方类型
+	Type string `json:"type"`
+	// 接收方账号
+	Account string `json:"account"`
+	// 接收方名称，商户为商户全称，个人为姓名（明文，添加时会加密）
+	Name string `json:"name"`
+	// 与分账方的关系类型
+	RelationType string `json:"relation_type"`
+}
+
+func (r ProfitShareReceiver) key() string {
+	return r.Type + "/" + r.Account
+}
+
+// 分账接收方登记簿的存储
+type ReceiverRegistryStore interface {
+	List() (receivers []ProfitShareReceiver, err error)
+	Put(receiver ProfitShareReceiver) (err error)
+	Delete(receiverType string, account string) (err error)
+}
+
+type memoryReceiverRegistryStore struct {
+	mu        sync.RWMutex
+	receivers map[string]ProfitShareReceiver
+}
+
+func NewMemoryReceiverRegistryStore() ReceiverRegistryStore {
+	return &memoryReceiverRegistryStore{receivers: make(map[string]ProfitShareReceiver)}
+}
+
+func (s *memoryReceiverRegistryStore) List() (receivers []ProfitShareReceiver, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.receivers {
+		receivers = append(receivers, r)
+	}
+	sort.Slice(receivers, func(i, j int) bool {
+		return receivers[i].key() < receivers[j].key()
+	})
+	return
+}
+
+func (s *memoryReceiverRegistryStore) Put(receiver ProfitShareReceiver) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.receivers[receiver.key()] = receiver
+	return
+}
+
+func (s *memoryReceiverRegistryStore) Delete(receiverType string, account string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.receivers, receiverType+"/"+account)
+	return
+}
+
+// 同步操作
+type ReceiverSyncAction string
+
+// 添加
+const ReceiverSyncAdd ReceiverSyncAction = "ADD"
+
+// 信息变化，重新添加
+const ReceiverSyncUpdate ReceiverSyncAction = "UPDATE"
+
+// 删除
+const ReceiverSyncDelete ReceiverSyncAction = "DELETE"
+
+// 没有变化
+const ReceiverSyncUnchanged ReceiverSyncAction = "UNCHANGED"
+
+// 同步结果
+type ReceiverSyncStatus string
+
+// 成功
+const ReceiverSyncSuccess ReceiverSyncStatus = "SUCCESS"
+
+// 接收方已经存在，按成功处理
+const ReceiverSyncAlreadyExists ReceiverSyncStatus = "ALREADY_EXISTS"
+
+// 失败
+const ReceiverSyncFailed ReceiverSyncStatus = "FAILED"
+
+// 一个接收方的同步结果
+type ReceiverSyncOutcome struct {
+	Receiver ProfitShareReceiver `json:"receiver"`
+	Action   ReceiverSyncAction  `json:"action"`
+	Status   ReceiverSyncStatus  `json:"status"`
+	// 失败原因
+	Error error `json:"-"`
+}
+
+// 同步结果
+type ReceiverSyncResult struct {
+	Outcomes []ReceiverSyncOutcome
+}
+
+// 失败的接收方
+func (r *ReceiverSyncResult) Failed() (outcomes []ReceiverSyncOutcome) {
+	for _, o := range r.Outcomes {
+		if o.Status == ReceiverSyncFailed {
+			outcomes = append(outcomes, o)
+		}
+	}
+	return
+}
+
+// 分账接收方登记簿
+type ReceiverRegistry struct {
+	client ProfitSharingService
+	store  ReceiverRegistryStore
+	appID  string
+	mu     sync.Mutex
+}
+
+// appID为电商平台的appid
+func NewReceiverRegistry(client ProfitSharingService, store ReceiverRegistryStore, appID string) *ReceiverRegistry {
+	return &ReceiverRegistry{client: client, store: store, appID: appID}
+}
+
+// 登记簿中已添加的接收方
+func (r *ReceiverRegistry) List() (receivers []ProfitShareReceiver, err error) {
+	receivers, err = r.store.List()
+	return
+}
+
+// 校验接收方信息
+func validateReceiver(receiver ProfitShareReceiver) error {
+	var errs ValidationErrors
+	switch receiver.Type {
+	case ReceiverTypeMerchant:
+		requireString(&errs, "/name", receiver.Name)
+	case ReceiverTypePersonalOpenID:
+	default:
+		errs.add("/type", receiver.Type, "接收方类型应为%s或%s", ReceiverTypeMerchant, ReceiverTypePersonalOpenID)
+	}
+	requireString(&errs, "/account", receiver.Account)
+	if !isReceiverRelationType(receiver.RelationType) {
+		errs.add("/relation_type", receiver.RelationType, "不支持的关系类型")
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// 把登记簿同步为desired，单个接收方失败不影响其他接收方，只在读取登记簿失败时返回错误
+func (r *ReceiverRegistry) Sync(ctx context.Context, desired []ProfitShareReceiver) (result *ReceiverSyncResult, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, err := r.store.List()
+	if err != nil {
+		return
+	}
+	existing := make(map[string]ProfitShareReceiver, len(current))
+	for _, receiver := range current {
+		existing[receiver.key()] = receiver
+	}
+
+	result = &ReceiverSyncResult{}
+	wanted := make(map[string]bool, len(desired))
+	for _, receiver := range desired {
+		outcome := ReceiverSyncOutcome{Receiver: receiver, Action: ReceiverSyncAdd}
+		old, ok := existing[receiver.key()]
+		switch {
+		case wanted[receiver.key()]:
+			outcome.Status = ReceiverSyncFailed
+			outcome.Error = ValidationErrors{{Field: "/account", Value: receiver.Account, Issue: "接收方重复"}}
+		case ok && old == receiver:
+			outcome.Action = ReceiverSyncUnchanged
+			outcome.Status = ReceiverSyncSuccess
+		default:
+			if ok {
+				outcome.Action = ReceiverSyncUpdate
+			}
+			outcome.Status, outcome.Error = r.add(ctx, receiver)
+		}
+		wanted[receiver.key()] = true
+		result.Outcomes = append(result.Outcomes, outcome)
+	}
+
+	for _, receiver := range current {
+		if wanted[receiver.key()] {
+			continue
+		}
+		outcome := ReceiverSyncOutcome{Receiver: receiver, Action: ReceiverSyncDelete, Status: ReceiverSyncSuccess}
+		_, outcome.Error = r.client.ReceiversDelete(ctx, ReceiversDeleteRequest{
+			AppID:   r.appID,
+			Type:    receiver.Type,
+			Account: receiver.Account,
+		})
+		if outcome.Error == nil {
+			outcome.Error = r.store.Delete(receiver.Type, receiver.Account)
+		}
+		if outcome.Error != nil {
+			outcome.Status = ReceiverSyncFailed
+		}
+		result.Outcomes = append(result.Outcomes, outcome)
+	}
+	return
+}
+
+// 添加接收方并保存到登记簿
+func (r *ReceiverRegistry) add(ctx context.Context, receiver ProfitShareReceiver) (status ReceiverSyncStatus, err error) {
+	status = ReceiverSyncFailed
+	err = validateReceiver(receiver)
+	if err != nil {
+		return
+	}
+	req := ReceiversAddRequest{
+		AppID:        r.appID,
+		Type:         receiver.Type,
+		Account:      receiver.Account,
+		RelationType: receiver.RelationType,
+	}
+	// 个人姓名由ReceiversAdd加密后放在encrypted_name中
+	if receiver.Type == ReceiverTypePersonalOpenID {
+		req.EncryptedName = receiver.Name
+	} else {
+		req.Name = receiver.Name
+	}
+	_, err = r.client.ReceiversAdd(ctx, req)
+	switch e := err.(type) {
+	case nil:
+		status = ReceiverSyncSuccess
+	case errIdempotent:
+		if !e.IsIdempotent() {
+			return
+		}
+		status = ReceiverSyncAlreadyExists
+		err = nil
+	default:
+		return
+	}
+	err = r.store.Put(receiver)
+	if err != nil {
+		status = ReceiverSyncFailed
+	}
+	return
+}
@@ -0,0 +1,388 @@
+package wxmch_api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+	单笔订单的多次分账流程
+	记录每次请求分账的商户分账单号和查询结果，轮询处理中的分账单，
+	计划的分账全部成功或到达截止时间后自动完结分账，解冻剩余资金给二级商户
+*/
+
+// 计划的分账全部成功后完结分账的描述
+const DefaultProfitShareFinishDescription = "分账已完成"
+
+// 到达截止时间后完结分账的描述
+const DefaultProfitShareDeadlineDescription = "分账到期完结"
+
+var ErrProfitShareWorkflowNotFound = errors.New("分账流程不存在")
+
+var ErrProfitShareWorkflowFinished = errors.New("分账流程已完结")
+
+// 一次请求分账
+type ProfitShareSplit struct {
+	// 商户分账单号
+	OutOrderNo string `json:"out_order_no"`
+	// 微信分账单号
+	OrderID string `json:"order_id"`
+	// 请求的分账接收方
+	Receivers []ReceiverInProfitShareRequest `json:"receivers"`
+	// 分账单状态，请求失败时为空
+	Status string `json:"status"`
+	// 最近一次查询到的接收方分账结果
+	Results []ReceiverInProfitShareResponse `json:"results,omitempty"`
+	// 请求或查询失败原因
+	Error string `json:"error,omitempty"`
+	// 请求时间
+	SubmittedAt time.Time `json:"submitted_at"`
+	// 最近一次更新时间
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 请求的分账金额
+func (s ProfitShareSplit) Amount() (amount uint) {
+	for _, r := range s.Receivers {
+		amount += r.Amount
+	}
+	return
+}
+
+// 分账单处理完成且所有接收方都分账成功
+func (s ProfitShareSplit) Succeeded() bool {
+	if s.Status != ProfitShareStatusFinished || len(s.Results) == 0 {
+		return false
+	}
+	for _, r := range s.Results {
+		if r.Result != ProfitShareResultSuccess {
+			return false
+		}
+	}
+	return true
+}
+
+// 分账流程状态
+type ProfitShareWorkflowState struct {
+	// 二级商户号
+	SubMchID string `json:"sub_mchid"`
+	// 微信订单号
+	TransactionID string `json:"transaction_id"`
+	// 计划的分账次数，全部成功后自动完结，为0时只在截止时间完结
+	PlannedSplits int `json:"planned_splits"`
+	// 截止时间，到期后自动完结，为零值时不限制
+	Deadline time.Time `json:"deadline"`
+	// 完结分账的商户分账单号，为空时使用微信订单号加F
+	FinishOutOrderNo string `json:"finish_out_order_no,omitempty"`
+	// 计划的分账全部成功后完结分账的描述
+	FinishDescription string `json:"finish_description,omitempty"`
+	// 到达截止时间后完结分账的描述
+	DeadlineDescription string `json:"deadline_description,omitempty"`
+	// 请求分账记录
+	Splits []ProfitShareSplit `json:"splits"`
+	// 是否已完结
+	Finished bool `json:"finished"`
+	// 完结时间
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	// 创建时间
+	CreatedAt time.Time `json:"created_at"`
+	// 更新时间
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 分账流程的存储
+type ProfitShareWorkflowStore interface {
+	Save(state ProfitShareWorkflowState) (err error)
+	// 流程不存在时返回nil
+	Load(transactionID string) (state *ProfitShareWorkflowState, err error)
+}
+
+type memoryProfitShareWorkflowStore struct {
+	mu     sync.RWMutex
+	states map[string]ProfitShareWorkflowState
+}
+
+func NewMemoryProfitShareWorkflowStore() ProfitShareWorkflowStore {
+	return &memoryProfitShareWorkflowStore{states: make(map[string]ProfitShareWorkflowState)}
+}
+
+func (s *memoryProfitShareWorkflowStore) Save(state ProfitShareWorkflowState) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state.Splits = append([]ProfitShareSplit(nil), state.Splits...)
+	s.states[state.TransactionID] = state
+	return
+}
+
+func (s *memoryProfitShareWorkflowStore) Load(transactionID string) (state *ProfitShareWorkflowState, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if st, ok := s.states[transactionID]; ok {
+		st.Splits = append([]ProfitShareSplit(nil), st.Splits...)
+		state = &st
+	}
+	return
+}
+
+type ProfitShareWorkflow struct {
+	client ProfitSharingService
+	store  ProfitShareWorkflowStore
+	mu     sync.Mutex
+	state  ProfitShareWorkflowState
+}
+
+// 创建分账流程并保存
+func NewProfitShareWorkflow(client ProfitSharingService, store ProfitShareWorkflowStore, state ProfitShareWorkflowState) (w *ProfitShareWorkflow, err error) {
+	if state.TransactionID == "" {
+		err = errors.New("微信订单号不能为空")
+		return
+	}
+	w = &ProfitShareWorkflow{client: client, store: store, state: state}
+	err = w.save()
+	return
+}
+
+// 加载已保存的分账流程
+func LoadProfitShareWorkflow(client ProfitSharingService, store ProfitShareWorkflowStore, transactionID string) (w *ProfitShareWorkflow, err error) {
+	state, err := store.Load(transactionID)
+	if err != nil {
+		return
+	}
+	if state == nil {
+		err = ErrProfitShareWorkflowNotFound
+		return
+	}
+	w = &ProfitShareWorkflow{client: client, store: store, state: *state}
+	return
+}
+
+// 当前状态的副本
+func (w *ProfitShareWorkflow) State() ProfitShareWorkflowState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state := w.state
+	state.Splits = append([]ProfitShareSplit(nil), w.state.Splits...)
+	return state
+}
+
+func (w *ProfitShareWorkflow) save() error {
+	w.state.UpdatedAt = time.Now()
+	if w.state.CreatedAt.IsZero() {
+		w.state.CreatedAt = w.state.UpdatedAt
+	}
+	return w.store.Save(w.state)
+}
+
+func (w *ProfitShareWorkflow) findSplit(outOrderNo string) *ProfitShareSplit {
+	for i := range w.state.Splits {
+		if w.state.Splits[i].OutOrderNo == outOrderNo {
+			return &w.state.Splits[i]
+		}
+	}
+	return nil
+}
+
+// 请求一次分账，相同的商户分账单号只会成功请求一次，请求失败时可以用相同的单号重试
+func (w *ProfitShareWorkflow) Split(ctx context.Context, outOrderNo string, receivers []ReceiverInProfitShareRequest) (split ProfitShareSplit, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.state.Finished {
+		err = ErrProfitShareWorkflowFinished
+		return
+	}
+	s := w.findSplit(outOrderNo)
+	if s != nil && s.Status != "" {
+		split = *s
+		return
+	}
+	if s == nil {
+		w.state.Splits = append(w.state.Splits, ProfitShareSplit{OutOrderNo: outOrderNo})
+		s = &w.state.Splits[len(w.state.Splits)-1]
+	}
+	s.Receivers = append([]ReceiverInProfitShareRequest(nil), receivers...)
+	s.SubmittedAt = time.Now()
+	s.UpdatedAt = s.SubmittedAt
+	resp, err := w.client.ProfitShareApply(ctx, ProfitShareApplyRequest{
+		SubMchID:      w.state.SubMchID,
+		TransactionID: w.state.TransactionID,
+		OutOrderNo:    outOrderNo,
+		Receivers:     receivers,
+	})
+	if err != nil {
+		s.Error = err.Error()
+	} else {
+		s.Error = ""
+		s.OrderID = resp.OrderID
+		s.Status = ProfitShareStatusProcessing
+	}
+	split = *s
+	if saveErr := w.save(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return
+}
+
+// 按分账计划请求一次分账
+func (w *ProfitShareWorkflow) SplitPlan(ctx context.Context, outOrderNo string, plan *ProfitSharePlan) (split ProfitShareSplit, err error) {
+	split, err = w.Split(ctx, outOrderNo, plan.Receivers)
+	return
+}
+
+// 已经分出和处理中的金额，不包括已关闭的接收方
+func (w *ProfitShareWorkflow) SharedAmount() (amount uint) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, s := range w.state.Splits {
+		if s.Status == "" {
+			continue
+		}
+		if len(s.Results) == 0 {
+			amount += s.Amount()
+			continue
+		}
+		for _, r := range s.Results {
+			if r.Result != ProfitShareResultClosed {
+				amount += r.Amount
+			}
+		}
+	}
+	return
+}
+
+// 查询订单剩余待分金额
+func (w *ProfitShareWorkflow) Remaining(ctx context.Context) (amount uint, err error) {
+	resp, err := w.client.ProfitShareUnSplitAmountQuery(ctx, ProfitShareUnSplitAmountQueryRequest{TransactionID: w.state.TransactionID})
+	if err != nil {
+		return
+	}
+	amount = resp.UnSplitAmount
+	return
+}
+
+// 查询所有处理中的分账单，满足条件时自动完结，返回是否已完结
+func (w *ProfitShareWorkflow) Poll(ctx context.Context) (finished bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.state.Finished {
+		finished = true
+		return
+	}
+	for i := range w.state.Splits {
+		s := &w.state.Splits[i]
+		if s.Status != ProfitShareStatusProcessing {
+			continue
+		}
+		resp, queryErr := w.client.ProfitShareQuery(ctx, ProfitShareQueryRequest{
+			SubMchID:      w.state.SubMchID,
+			TransactionID: w.state.TransactionID,
+			OutOrderNo:    s.OutOrderNo,
+		})
+		s.UpdatedAt = time.Now()
+		if queryErr != nil {
+			s.Error = queryErr.Error()
+			continue
+		}
+		s.Error = ""
+		s.OrderID = resp.OrderID
+		s.Status = resp.Status
+		s.Results = resp.Receivers
+	}
+	if description, ok := w.finishDescription(); ok {
+		err = w.finish(ctx, description)
+	}
+	finished = w.state.Finished
+	if saveErr := w.save(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return
+}
+
+// 是否满足自动完结的条件
+func (w *ProfitShareWorkflow) finishDescription() (description string, ok bool) {
+	succeeded := 0
+	for _, s := range w.state.Splits {
+		if s.Status == ProfitShareStatusProcessing {
+			return
+		}
+		if s.Succeeded() {
+			succeeded++
+		}
+	}
+	switch {
+	case w.state.PlannedSplits > 0 && succeeded >= w.state.PlannedSplits:
+		description = w.state.FinishDescription
+		if description == "" {
+			description = DefaultProfitShareFinishDescription
+		}
+		ok = true
+	case !w.state.Deadline.IsZero() && time.Now().After(w.state.Deadline):
+		description = w.state.DeadlineDescription
+		if description == "" {
+			description = DefaultProfitShareDeadlineDescription
+		}
+		ok = true
+	}
+	return
+}
+
+// 完结分账，剩余待分金额为0时不需要调用完结分账
+func (w *ProfitShareWorkflow) Finish(ctx context.Context, description string) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.state.Finished {
+		return
+	}
+	err = w.finish(ctx, description)
+	if err != nil {
+		return
+	}
+	err = w.save()
+	return
+}
+
+func (w *ProfitShareWorkflow) finish(ctx context.Context, description string) (err error) {
+	remaining, err := w.Remaining(ctx)
+	if err != nil {
+		return
+	}
+	if remaining > 0 {
+		outOrderNo := w.state.FinishOutOrderNo
+		if outOrderNo == "" {
+			outOrderNo = fmt.Sprintf("%sF", w.state.TransactionID)
+		}
+		_, err = w.client.ProfitShareFinish(ctx, ProfitShareFinishRequest{
+			SubMchID:      w.state.SubMchID,
+			TransactionID: w.state.TransactionID,
+			OutOrderNo:    outOrderNo,
+			Description:   description,
+		})
+		if err != nil {
+			return
+		}
+	}
+	w.state.Finished = true
+	w.state.FinishedAt = time.Now()
+	return
+}
+
+// 按interval轮询直到完结或ctx结束，查询失败记录在分账单的Error中，完结或保存失败时返回
+func (w *ProfitShareWorkflow) Run(ctx context.Context, interval time.Duration) (err error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		finished, pollErr := w.Poll(ctx)
+		if pollErr != nil || finished {
+			err = pollErr
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-ticker.C:
+		}
+	}
+}
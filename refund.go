@@ -13,9 +13,9 @@ import (
 
 type RefundRequest struct {
 	// 二级商户号
-	SubMchID string `json:"sub_mchid"`
+	SubMchID string `json:"sub_mchid" validate:"required"`
 	// 电商平台APPID
-	SpAppID string `json:"sp_appid"`
+	SpAppID string `json:"sp_appid" validate:"required"`
 	// 二级商户APPID
 	SubAppID string `json:"sub_appid,omitempty"`
 	// 微信订单号
@@ -23,17 +23,17 @@ type RefundRequest struct {
 	// 商户订单号
 	OutTradeNo string `json:"out_trade_no,omitempty"`
 	// 商户退款单号
-	OutRefundNo string `json:"out_refund_no"`
+	OutRefundNo string `json:"out_refund_no" validate:"required,max=64"`
 	// 退款原因
-	Reason string `json:"reason,omitempty"`
+	Reason string `json:"reason,omitempty" validate:"max=80"`
 	//订单金额
 	Amount struct {
 		// 退款金额
-		Refund uint `json:"refund"`
+		Refund uint `json:"refund" validate:"required"`
 		// 原订单金额
-		Total uint `json:"total"`
+		Total uint `json:"total" validate:"required"`
 		// 退款币种
-		Currency string `json:"currency"`
+		Currency string `json:"currency" validate:"required"`
 	} `json:"amount"`
 	// 退款结果回调url
 	NotifyUrl string `json:"notify_url"`
@@ -72,9 +72,23 @@ type RefundResponse struct {
 	} `json:"promotion_detail"`
 }
 
+func (r RefundRequest) validate() (errs ValidationErrors) {
+	if r.TransactionID == "" && r.OutTradeNo == "" {
+		errs.add("/transaction_id", r.TransactionID, "transaction_id和out_trade_no不能同时为空")
+	}
+	if r.Amount.Refund > r.Amount.Total {
+		errs.add("/amount/refund", r.Amount.Refund, "退款金额不能大于原订单金额%d", r.Amount.Total)
+	}
+	return
+}
+
 // 申请退款
 func (c MerchantApiClient) RefundApply(ctx context.Context, req RefundRequest) (resp *RefundResponse, err error) {
 	url := "/v3/ecommerce/refunds/apply"
+	err = Validate(req)
+	if err != nil {
+		return
+	}
 	body, _ := json.Marshal(&req)
 	res, err := c.doRequestAndVerifySignature(ctx, "POST", url, nil, body)
 	if err != nil {
@@ -0,0 +1,381 @@
+package wxmch_api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+	已分账订单的退款
+	二级商户的资金已经分给接收方时直接退款会因余额不足失败，
+	先按ProfitShareQuery的结果计算每个接收方需要回退的金额，逐个分账回退并等待结果，再提交退款。
+	回退单号由商户退款单号生成，重复执行同一个退款单号会从上次中断的位置继续；
+	回退成功但退款失败时记录补偿步骤，由人工处理已经回退的资金
+*/
+
+const defaultRefundSagaPollInterval = 3 * time.Second
+
+// 商户回退单号最长64位，商户退款单号后面追加"_"和3位以内的序号
+const maxRefundSagaReturns = 999
+const maxRefundSagaOutRefundNoLen = 64 - 4
+
+// 退款流程状态
+type RefundSagaStatus string
+
+// 进行中
+const RefundSagaRunning RefundSagaStatus = "RUNNING"
+
+// 退款已提交
+const RefundSagaSucceeded RefundSagaStatus = "SUCCEEDED"
+
+// 失败，需要处理补偿步骤
+const RefundSagaFailed RefundSagaStatus = "FAILED"
+
+var ErrNotEnoughReturnable = errors.New("可回退的分账金额不足")
+var ErrTooManyProfitReturns = fmt.Errorf("需要回退的接收方超过%d个", maxRefundSagaReturns)
+
+type RefundSagaRequest struct {
+	// 退款请求
+	Refund RefundRequest `json:"refund"`
+	// 订单已有分账的商户分账单号
+	OutOrderNos []string `json:"out_order_nos"`
+	// 订单之前已经退款的金额
+	RefundedAmount uint `json:"refunded_amount"`
+}
+
+// 一次分账回退
+type ProfitReturnStep struct {
+	// 商户分账单号
+	OutOrderNo string `json:"out_order_no"`
+	// 商户回退单号
+	OutReturnNo string `json:"out_return_no"`
+	// 回退商户号
+	ReturnMchID string `json:"return_mchid"`
+	// 回退金额
+	Amount uint `json:"amount"`
+	// 微信回退单号
+	ReturnNo string `json:"return_no"`
+	// 回退结果，未提交时为空
+	Result string `json:"result"`
+	// 失败原因
+	Error string `json:"error,omitempty"`
+	// 更新时间
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 补偿步骤：已经从接收方回退的资金需要重新支付给接收方
+type RefundCompensation struct {
+	// 回退商户号
+	ReturnMchID string `json:"return_mchid"`
+	// 商户分账单号
+	OutOrderNo string `json:"out_order_no"`
+	// 商户回退单号
+	OutReturnNo string `json:"out_return_no"`
+	// 已回退的金额
+	Amount uint `json:"amount"`
+	// 需要补偿的原因
+	Reason string `json:"reason"`
+}
+
+// 退款流程状态
+type RefundSagaState struct {
+	RefundSagaRequest
+	// 流程状态
+	Status RefundSagaStatus `json:"status"`
+	// 是否已经计算回退计划
+	Planned bool `json:"planned"`
+	// 分账回退步骤
+	Returns []ProfitReturnStep `json:"returns"`
+	// 微信退款单号
+	RefundID string `json:"refund_id"`
+	// 失败原因
+	Error string `json:"error,omitempty"`
+	// 补偿步骤
+	Compensations []RefundCompensation `json:"compensations,omitempty"`
+	// 创建时间
+	CreatedAt time.Time `json:"created_at"`
+	// 更新时间
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 退款流程的存储
+type RefundSagaStore interface {
+	Save(state RefundSagaState) (err error)
+	// 流程不存在时返回nil
+	Load(outRefundNo string) (state *RefundSagaState, err error)
+}
+
+type memoryRefundSagaStore struct {
+	mu     sync.RWMutex
+	states map[string]RefundSagaState
+}
+
+func NewMemoryRefundSagaStore() RefundSagaStore {
+	return &memoryRefundSagaStore{states: make(map[string]RefundSagaState)}
+}
+
+func (s *memoryRefundSagaStore) Save(state RefundSagaState) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state.Returns = append([]ProfitReturnStep(nil), state.Returns...)
+	s.states[state.Refund.OutRefundNo] = state
+	return
+}
+
+func (s *memoryRefundSagaStore) Load(outRefundNo string) (state *RefundSagaState, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if st, ok := s.states[outRefundNo]; ok {
+		st.Returns = append([]ProfitReturnStep(nil), st.Returns...)
+		state = &st
+	}
+	return
+}
+
+type RefundSaga struct {
+	refunds       RefundService
+	profitSharing ProfitSharingService
+	store         RefundSagaStore
+	// 查询分账回退结果的间隔
+	PollInterval time.Duration
+}
+
+func NewRefundSaga(refunds RefundService, profitSharing ProfitSharingService, store RefundSagaStore) *RefundSaga {
+	return &RefundSaga{
+		refunds:       refunds,
+		profitSharing: profitSharing,
+		store:         store,
+		PollInterval:  defaultRefundSagaPollInterval,
+	}
+}
+
+// 执行退款流程，相同的商户退款单号从上次中断的位置继续
+func (s *RefundSaga) Run(ctx context.Context, req RefundSagaRequest) (state *RefundSagaState, err error) {
+	// 回退之前校验退款请求，避免资金回退后才发现参数错误
+	err = Validate(req.Refund)
+	if err != nil {
+		return
+	}
+	if len(req.Refund.OutRefundNo) > maxRefundSagaOutRefundNoLen {
+		err = ValidationErrors{{Field: "/refund/out_refund_no", Value: req.Refund.OutRefundNo, Issue: fmt.Sprintf("最长%d位，需要为商户回退单号预留后缀", maxRefundSagaOutRefundNoLen)}}
+		return
+	}
+	// 查询分账结果需要微信订单号，只有商户订单号时无法回退
+	if req.Refund.TransactionID == "" {
+		err = ValidationErrors{{Field: "/refund/transaction_id", Value: req.Refund.TransactionID, Issue: "已分账订单的退款必须填写微信订单号"}}
+		return
+	}
+	state, err = s.store.Load(req.Refund.OutRefundNo)
+	if err != nil {
+		return
+	}
+	if state == nil {
+		state = &RefundSagaState{RefundSagaRequest: req, Status: RefundSagaRunning, CreatedAt: time.Now()}
+	}
+	if state.Status != RefundSagaRunning {
+		return
+	}
+
+	if !state.Planned {
+		err = s.plan(ctx, state)
+		if err == ErrNotEnoughReturnable || err == ErrTooManyProfitReturns {
+			s.fail(state, err)
+		}
+		if saveErr := s.save(state); saveErr != nil && err == nil {
+			err = saveErr
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	for i := range state.Returns {
+		step := &state.Returns[i]
+		if step.Result == ProfitReturnResultSuccess {
+			continue
+		}
+		err = s.runReturn(ctx, state, step)
+		if step.Result == ProfitReturnResultFailed {
+			s.fail(state, fmt.Errorf("分账回退%s失败:%s", step.OutReturnNo, step.Error))
+			err = errors.New(state.Error)
+		}
+		if saveErr := s.save(state); saveErr != nil && err == nil {
+			err = saveErr
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	resp, err := s.refunds.RefundApply(ctx, state.Refund)
+	if err != nil {
+		if isRetryableError(err) {
+			// 结果不确定或系统繁忙，保持进行中，重新执行时用相同的退款单号重试
+			state.Error = err.Error()
+		} else {
+			// 微信支付明确拒绝了退款，已回退的资金需要补偿
+			s.fail(state, err)
+		}
+	} else {
+		state.RefundID = resp.RefundID
+		state.Status = RefundSagaSucceeded
+		state.Error = ""
+	}
+	if saveErr := s.save(state); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return
+}
+
+func (s *RefundSaga) save(state *RefundSagaState) error {
+	state.UpdatedAt = time.Now()
+	return s.store.Save(*state)
+}
+
+// 标记失败并为已成功的回退记录补偿步骤
+func (s *RefundSaga) fail(state *RefundSagaState, cause error) {
+	state.Status = RefundSagaFailed
+	state.Error = cause.Error()
+	state.Compensations = nil
+	for _, step := range state.Returns {
+		if step.Result != ProfitReturnResultSuccess {
+			continue
+		}
+		state.Compensations = append(state.Compensations, RefundCompensation{
+			ReturnMchID: step.ReturnMchID,
+			OutOrderNo:  step.OutOrderNo,
+			OutReturnNo: step.OutReturnNo,
+			Amount:      step.Amount,
+			Reason:      state.Error,
+		})
+	}
+}
+
+// 按分账结果计算需要回退的金额，从最后一次分账的接收方开始回退
+func (s *RefundSaga) plan(ctx context.Context, state *RefundSagaState) (err error) {
+	var shared uint
+	var splits []*ProfitShareQueryResponse
+	for _, outOrderNo := range state.OutOrderNos {
+		resp, queryErr := s.profitSharing.ProfitShareQuery(ctx, ProfitShareQueryRequest{
+			SubMchID:      state.Refund.SubMchID,
+			TransactionID: state.Refund.TransactionID,
+			OutOrderNo:    outOrderNo,
+		})
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		splits = append(splits, resp)
+		for _, r := range resp.Receivers {
+			if r.Result != ProfitShareResultClosed {
+				shared += r.Amount
+			}
+		}
+	}
+
+	// 二级商户在该订单上剩余的资金
+	var kept uint
+	if total := state.Refund.Amount.Total; total > shared+state.RefundedAmount {
+		kept = total - shared - state.RefundedAmount
+	}
+	var need uint
+	if refund := state.Refund.Amount.Refund; refund > kept {
+		need = refund - kept
+	}
+
+	state.Returns = nil
+	for i := len(splits) - 1; i >= 0 && need > 0; i-- {
+		receivers := splits[i].Receivers
+		for j := len(receivers) - 1; j >= 0 && need > 0; j-- {
+			r := receivers[j]
+			// 只能从分账成功的商户回退
+			if r.Result != ProfitShareResultSuccess || r.Type != ReceiverTypeMerchant {
+				continue
+			}
+			amount := r.Amount
+			if amount > need {
+				amount = need
+			}
+			need -= amount
+			state.Returns = append(state.Returns, ProfitReturnStep{
+				OutOrderNo:  splits[i].OutOrderNo,
+				OutReturnNo: fmt.Sprintf("%s_%d", state.Refund.OutRefundNo, len(state.Returns)),
+				ReturnMchID: r.ReceiverMchID,
+				Amount:      amount,
+			})
+		}
+	}
+	if need > 0 {
+		state.Returns = nil
+		err = ErrNotEnoughReturnable
+		return
+	}
+	if len(state.Returns) > maxRefundSagaReturns {
+		state.Returns = nil
+		err = ErrTooManyProfitReturns
+		return
+	}
+	state.Planned = true
+	return
+}
+
+// 提交一次分账回退并等待结果
+func (s *RefundSaga) runReturn(ctx context.Context, state *RefundSagaState, step *ProfitReturnStep) (err error) {
+	if step.Result == "" {
+		description := state.Refund.Reason
+		if description == "" {
+			description = "退款回退"
+		}
+		resp, applyErr := s.profitSharing.ProfitReturnApply(ctx, ProfitReturnApplyRequest{
+			SubMchID:    state.Refund.SubMchID,
+			OutOrderNo:  step.OutOrderNo,
+			OutReturnNo: step.OutReturnNo,
+			ReturnMchID: step.ReturnMchID,
+			Amount:      step.Amount,
+			Description: description,
+		})
+		step.UpdatedAt = time.Now()
+		if e, ok := applyErr.(errIdempotent); ok && e.IsIdempotent() {
+			// 回退单已经提交过，查询结果
+			step.Result = ProfitReturnResultProcessing
+		} else if applyErr != nil {
+			step.Error = applyErr.Error()
+			err = applyErr
+			return
+		} else {
+			step.ReturnNo = resp.ReturnNo
+			step.Result = resp.Result
+			step.Error = resp.FailReason
+		}
+	}
+
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = defaultRefundSagaPollInterval
+	}
+	for step.Result == ProfitReturnResultProcessing {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(interval):
+		}
+		resp, queryErr := s.profitSharing.ProfitReturnQuery(ctx, ProfitReturnQueryRequest{
+			SubMchID:    state.Refund.SubMchID,
+			OutOrderNo:  step.OutOrderNo,
+			OutReturnNo: step.OutReturnNo,
+		})
+		step.UpdatedAt = time.Now()
+		if queryErr != nil {
+			step.Error = queryErr.Error()
+			continue
+		}
+		step.ReturnNo = resp.ReturnNo
+		step.Result = resp.Result
+		step.Error = resp.FailReason
+	}
+	return
+}
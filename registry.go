@@ -0,0 +1,138 @@
+package wxmch_api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+/*
+	多商户客户端注册表
+	每个商户号对应一个MerchantApiClient，各自持有商户私钥、证书序列号、APIv3密钥和平台证书
+*/
+
+var ErrNotificationMerchantNotFound = errors.New("没有能处理该通知的商户")
+
+type MerchantRegistry struct {
+	mu      sync.RWMutex
+	clients map[string]MerchantApiClient
+}
+
+func NewMerchantRegistry(clients ...MerchantApiClient) *MerchantRegistry {
+	r := &MerchantRegistry{clients: make(map[string]MerchantApiClient)}
+	for _, client := range clients {
+		r.Register(client)
+	}
+	return r
+}
+
+// 注册商户客户端，相同商户号会被覆盖
+func (r *MerchantRegistry) Register(client MerchantApiClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[client.MchID()] = client
+}
+
+// 移除商户客户端
+func (r *MerchantRegistry) Remove(mchID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, mchID)
+}
+
+// 获取商户客户端
+func (r *MerchantRegistry) Get(mchID string) (client MerchantApiClient, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	client, ok := r.clients[mchID]
+	if !ok {
+		err = fmt.Errorf("商户%s未注册", mchID)
+		return
+	}
+	return
+}
+
+// 已注册的商户号
+func (r *MerchantRegistry) MchIDs() (mchIDs []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for mchID := range r.clients {
+		mchIDs = append(mchIDs, mchID)
+	}
+	sort.Strings(mchIDs)
+	return
+}
+
+// 使用指定商户的客户端调用
+func (r *MerchantRegistry) Do(mchID string, fn func(client MerchantApiClient) error) (err error) {
+	client, err := r.Get(mchID)
+	if err != nil {
+		return
+	}
+	err = fn(client)
+	return
+}
+
+// 使用指定商户验签并解密回调通知，适用于通知地址中带商户号的场景
+func (r *MerchantRegistry) ParseNotificationFor(mchID string, header http.Header, body []byte) (client MerchantApiClient, n *Notification, plainText []byte, err error) {
+	client, err = r.Get(mchID)
+	if err != nil {
+		return
+	}
+	n, err = client.ParseNotification(header, body)
+	if err != nil {
+		return
+	}
+	plainText, err = client.GetResourcePlainText(n.Resource)
+	return
+}
+
+// 验签并解密回调通知，自动匹配通知所属商户
+// 先找到持有Wechatpay-Serial对应公钥的商户，再用各自的APIv3密钥解密，能解密的商户即通知所属商户
+func (r *MerchantRegistry) ParseNotification(header http.Header, body []byte) (client MerchantApiClient, n *Notification, plainText []byte, err error) {
+	var unverified Notification
+	err = json.Unmarshal(body, &unverified)
+	if err != nil {
+		return
+	}
+	serial := header.Get("Wechatpay-Serial")
+	r.mu.RLock()
+	var candidates []MerchantApiClient
+	for _, c := range r.clients {
+		if c.platformCertMap != nil && c.platformCertMap.GetPublicKey(serial) != nil {
+			candidates = append(candidates, c)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, c := range candidates {
+		// 解密失败说明APIv3密钥不属于该商户
+		if _, e := c.GetResourcePlainText(unverified.Resource); e != nil {
+			continue
+		}
+		client = c
+		n, err = c.ParseNotification(header, body)
+		if err != nil {
+			return
+		}
+		plainText, err = c.GetResourcePlainText(n.Resource)
+		return
+	}
+	err = ErrNotificationMerchantNotFound
+	return
+}
+
+// 自动匹配商户，验签、解密并去重处理回调通知
+func (r *MerchantRegistry) HandleNotification(header http.Header, body []byte, deduper *NotificationDeduper, handler func(client MerchantApiClient, n *Notification, plainText []byte) error) (duplicated bool, err error) {
+	client, n, plainText, err := r.ParseNotification(header, body)
+	if err != nil {
+		return
+	}
+	duplicated, err = deduper.Do(*n, plainText, func() error {
+		return handler(client, n, plainText)
+	})
+	return
+}
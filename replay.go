@@ -0,0 +1,128 @@
+package wxmch_api
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	应答和回调通知的防重放
+		Wechatpay-Timestamp与本地时间的偏差不能超过maxSkew
+		Wechatpay-Nonce在nonce缓存中不能重复出现
+*/
+
+// 推荐的时间戳偏差上限
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// 默认nonce缓存容量
+const DefaultNonceCacheSize = 100000
+
+var ErrTimestampExpired = errors.New("Wechatpay-Timestamp超出允许的时间偏差")
+var ErrNonceReplayed = errors.New("Wechatpay-Nonce重复，疑似重放")
+
+// nonce缓存
+// 容量应当能容纳maxSkew时间窗口内收到的应答和通知数量
+type NonceCache interface {
+	// 记录nonce，nonce已经存在时返回true
+	Seen(nonce string) bool
+}
+
+// 内存LRU nonce缓存
+type memoryNonceCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+// 创建内存LRU nonce缓存，capacity<=0时使用DefaultNonceCacheSize
+func NewMemoryNonceCache(capacity int) NonceCache {
+	if capacity <= 0 {
+		capacity = DefaultNonceCacheSize
+	}
+	return &memoryNonceCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryNonceCache) Seen(nonce string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.items[nonce]; ok {
+		m.ll.MoveToFront(e)
+		return true
+	}
+	m.items[nonce] = m.ll.PushFront(nonce)
+	if m.ll.Len() > m.capacity {
+		oldest := m.ll.Back()
+		m.ll.Remove(oldest)
+		delete(m.items, oldest.Value.(string))
+	}
+	return false
+}
+
+type replayGuard struct {
+	// 时间戳偏差上限，小于0表示不校验时间戳
+	maxSkew time.Duration
+	// nonce缓存
+	nonceCache NonceCache
+}
+
+// maxSkew为0时使用DefaultMaxClockSkew，nonceCache为nil时使用内存LRU缓存
+func newReplayGuard(maxSkew time.Duration, nonceCache NonceCache) *replayGuard {
+	if maxSkew == 0 {
+		maxSkew = DefaultMaxClockSkew
+	}
+	if nonceCache == nil {
+		nonceCache = NewMemoryNonceCache(DefaultNonceCacheSize)
+	}
+	return &replayGuard{maxSkew: maxSkew, nonceCache: nonceCache}
+}
+
+// 校验时间戳，在验签之前调用
+func (g *replayGuard) checkTimestamp(ts string) (err error) {
+	if g == nil || g.maxSkew <= 0 {
+		return
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		err = fmt.Errorf("Wechatpay-Timestamp格式错误:%s", ts)
+		return
+	}
+	skew := time.Since(time.Unix(sec, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > g.maxSkew {
+		err = ErrTimestampExpired
+		return
+	}
+	return
+}
+
+// 校验nonce，在验签通过之后调用，避免伪造的请求占满缓存
+func (g *replayGuard) checkNonce(nonce string) (err error) {
+	if g == nil || g.nonceCache == nil {
+		return
+	}
+	if g.nonceCache.Seen(nonce) {
+		err = ErrNonceReplayed
+		return
+	}
+	return
+}
+
+// 配置应答和回调通知的防重放校验，返回新的客户端
+// 客户端默认开启防重放校验：时间戳偏差不超过DefaultMaxClockSkew，nonce记录在内存LRU缓存中
+// maxSkew为0时使用DefaultMaxClockSkew，小于0时不校验时间戳；nonceCache为nil时使用内存LRU缓存
+// 多实例部署时应传入共享的nonce缓存
+func (c MerchantApiClient) WithReplayProtection(maxSkew time.Duration, nonceCache NonceCache) MerchantApiClient {
+	c.replayGuard = newReplayGuard(maxSkew, nonceCache)
+	return c
+}
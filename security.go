@@ -14,31 +14,16 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-
-	"golang.org/x/crypto/pkcs12"
 )
 
 // 生成rsa私钥
 func buildRSAPrivateKey(keyContent string) (priKey *rsa.PrivateKey, err error) {
-	block, _ := pem.Decode([]byte(keyContent))
-	if block == nil {
-		err = errors.New("private key error")
-		return
-	}
-	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-	if err != nil {
-		return
-	}
-	priKey, ok := key.(*rsa.PrivateKey)
-	if !ok {
-		err = errors.New("private key 不是rsa格式")
-		return
-	}
+	priKey, err = LoadPrivateKey(keyContent)
 	return
 }
 
 // 生成API调用时需要的签名
-func CreateSignature(method string, url string, ts int, nounce string, body []byte, priKey *rsa.PrivateKey) (signature string, err error) {
+func CreateSignature(method string, url string, ts int, nounce string, body []byte, signer crypto.Signer) (signature string, err error) {
 	// 签名前的字符串
 	sBeforeSign := strings.Join([]string{method, url, fmt.Sprintf("%d", ts), nounce}, "\n") + "\n"
 	if method == "GET" {
@@ -47,16 +32,16 @@ func CreateSignature(method string, url string, ts int, nounce string, body []by
 		sBeforeSign += string(body) + "\n"
 	}
 
-	signature, err = sha256WithRSA(sBeforeSign, priKey)
+	signature, err = sha256WithRSA(sBeforeSign, signer)
 	return
 }
 
-func sha256WithRSA(sBeforeSign string, priKey *rsa.PrivateKey) (signature string, err error) {
+func sha256WithRSA(sBeforeSign string, signer crypto.Signer) (signature string, err error) {
 	h := sha256.New()
 	_, _ = h.Write([]byte(sBeforeSign))
 	hashed := h.Sum(nil)
 
-	sign, err := rsa.SignPKCS1v15(rand.Reader, priKey, crypto.SHA256, hashed)
+	sign, err := signer.Sign(rand.Reader, hashed, crypto.SHA256)
 	if err != nil {
 		return
 	}
@@ -64,16 +49,20 @@ func sha256WithRSA(sBeforeSign string, priKey *rsa.PrivateKey) (signature string
 	return
 }
 
-func createPaySign(priKey *rsa.PrivateKey, args ...string) (paySign string, err error) {
+func createPaySign(signer crypto.Signer, args ...string) (paySign string, err error) {
 	sBeforeSign := strings.Join(args, "\n")
 	sBeforeSign += "\n"
-	paySign, err = sha256WithRSA(sBeforeSign, priKey)
+	paySign, err = sha256WithRSA(sBeforeSign, signer)
 	return
 }
 
 // 验证API返回和回调header中的微信签名
 func VerifyWechatSignature(ts string, nonce string, body []byte, b64Sig string, pub *rsa.PublicKey) (pass bool) {
 	// 签名前的字符串
+	if pub == nil {
+		pass = false
+		return
+	}
 	sBeforeSign := strings.Join([]string{ts, nonce, string(body)}, "\n") + "\n"
 	signature, err := base64.StdEncoding.DecodeString(b64Sig)
 	if err != nil {
@@ -94,22 +83,25 @@ func VerifyWechatSignature(ts string, nonce string, body []byte, b64Sig string,
 }
 
 // 用于平台证书解密和回调报文的解密
-func decryptCiphertextWithGCM(associatedData string, nonce string, ciphertext string, apiSecret string) (plaintext []byte) {
-	ct, _ := base64.StdEncoding.DecodeString(ciphertext)
+func decryptCiphertextWithGCM(associatedData string, nonce string, ciphertext string, apiSecret string) (plaintext []byte, err error) {
+	ct, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return
+	}
 	nc := []byte(nonce)
 	block, err := aes.NewCipher([]byte(apiSecret))
 	if err != nil {
-		panic(err.Error())
+		return
 	}
 
 	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
-		panic(err.Error())
+		return
 	}
 
 	plaintext, err = aesgcm.Open(nil, nc, ct, []byte(associatedData))
 	if err != nil {
-		panic(err.Error())
+		return
 	}
 	return
 }
@@ -123,27 +115,43 @@ func GetKeyFromCertificate(certContent string) (rsaPublicKey *rsa.PublicKey) {
 	return
 }
 
+// 从微信支付公钥(pub_key.pem)内容生成rsa公钥
+func BuildRSAPublicKey(keyContent string) (rsaPublicKey *rsa.PublicKey, err error) {
+	block, _ := pem.Decode([]byte(keyContent))
+	if block == nil {
+		err = errors.New("public key error")
+		return
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		rsaPublicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		return
+	default:
+		key, e := x509.ParsePKIXPublicKey(block.Bytes)
+		if e != nil {
+			err = e
+			return
+		}
+		pubKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			err = errors.New("public key 不是rsa格式")
+			return
+		}
+		rsaPublicKey = pubKey
+	}
+	return
+}
+
 // 从P12证书文件内容获取商户证书的公钥和私钥
 func ParseP12Cert(content []byte, password string) (rsaPublicKey *rsa.PublicKey, rsaPrivateKey *rsa.PrivateKey, err error) {
-	blocks, err := pkcs12.ToPEM(content, password)
+	cert, rsaPrivateKey, err := parseP12(content, password)
 	if err != nil {
 		return
 	}
-
-	for _, b := range blocks {
-		switch b.Type {
-		case "CERTIFICATE":
-			cert, _ := x509.ParseCertificate(b.Bytes)
-			rsaPublicKey = cert.PublicKey.(*rsa.PublicKey)
-
-		case "PRIVATE KEY":
-			priKey, e := x509.ParsePKCS1PrivateKey(b.Bytes)
-			if e != nil {
-				err = e
-				return
-			}
-			rsaPrivateKey = priKey
-		}
+	rsaPublicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		err = errors.New("证书公钥不是rsa格式")
+		return
 	}
 	return
 }
@@ -159,11 +167,11 @@ func encryptCiphertext(text string, rsaPublicKey *rsa.PublicKey) (ciphertext str
 }
 
 // 敏感信息的解密
-func decryptCiphertext(ciphertext string, rsaPrivateKey *rsa.PrivateKey) (text string, err error) {
+func decryptCiphertext(ciphertext string, decrypter crypto.Decrypter) (text string, err error) {
 	cipherdata, _ := base64.StdEncoding.DecodeString(ciphertext)
 	rng := rand.Reader
 
-	plaintext, err := rsa.DecryptOAEP(sha1.New(), rng, rsaPrivateKey, cipherdata, nil)
+	plaintext, err := decrypter.Decrypt(rng, cipherdata, &rsa.OAEPOptions{Hash: crypto.SHA1})
 	if err != nil {
 		return
 	}
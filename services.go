@@ -0,0 +1,191 @@
+package wxmch_api
+
+import (
+	"context"
+	"io"
+)
+
+/*
+	按业务分组的服务接口
+	MerchantApiClient实现了所有服务接口，业务代码依赖接口，单元测试时可以只替换其中一个服务
+*/
+
+// 普通支付
+type TransactionService interface {
+	JsApiPrepay(ctx context.Context, req JsApiPrepayRequest) (resp *PrepayPayResponse, err error)
+	PayResultQueryByTransactionID(ctx context.Context, req QueryPayResultByTransactionIDRequest) (resp *QueryPayResultResponse, err error)
+	PayResultQueryByOutRequestNo(ctx context.Context, req QueryPayResultByOutRequestNoRequest) (resp *QueryPayResultResponse, err error)
+	GenJsApiPayRequest(req JsApiPayRequest) (resp *JsApiPayResponse, err error)
+	Close(ctx context.Context, req CloseOrderRequest) (err error)
+}
+
+// 退款
+type RefundService interface {
+	RefundApply(ctx context.Context, req RefundRequest) (resp *RefundResponse, err error)
+	QueryRefundByID(ctx context.Context, req QueryRefundByIDRequest) (resp *QueryRefundResponse, err error)
+	QueryRefundByOutRefundNo(ctx context.Context, req QueryRefundByOutRefundNoRequest) (resp *QueryRefundResponse, err error)
+}
+
+// 分账
+type ProfitSharingService interface {
+	ProfitShareApply(ctx context.Context, req ProfitShareApplyRequest) (resp *ProfitShareApplyResponse, err error)
+	ProfitShareQuery(ctx context.Context, req ProfitShareQueryRequest) (resp *ProfitShareQueryResponse, err error)
+	ProfitReturnApply(ctx context.Context, req ProfitReturnApplyRequest) (resp *ProfitReturnApplyResponse, err error)
+	ProfitReturnQuery(ctx context.Context, req ProfitReturnQueryRequest) (resp ProfitReturnQueryResponse, err error)
+	ProfitShareUnSplitAmountQuery(ctx context.Context, req ProfitShareUnSplitAmountQueryRequest) (resp *ProfitShareUnSplitAmountQueryResponse, err error)
+	ProfitShareFinish(ctx context.Context, req ProfitShareFinishRequest) (resp *ProfitShareFinishResponse, err error)
+	ReceiversAdd(ctx context.Context, req ReceiversAddRequest) (resp *ReceiversAddResponse, err error)
+	ReceiversDelete(ctx context.Context, req ReceiversDeleteRequest) (resp *ReceiversDeleteResponse, err error)
+	ProfitShareBill(ctx context.Context, req ProfitShareBillRequest) (resp *BillDownloadInfo, err error)
+	EcommerceProfitShareBill(ctx context.Context, req ProfitShareBillRequest) (resp *BillDownloadInfo, err error)
+}
+
+// 批量转账到零钱
+type TransferService interface {
+	BatchTransfer(ctx context.Context, req BatchTransferRequest) (resp *BatchTransferResponse, err error)
+	BatchTransferQueryByOutNo(ctx context.Context, req BatchTransferQueryByOutNoRequest) (resp *BatchTransferQueryByOutNoResponse, err error)
+	BatchTransferQueryByID(ctx context.Context, req BatchTransferQueryByIDRequest) (resp *BatchTransferQueryByIDResponse, err error)
+	TransferDetailQueryByOutNo(ctx context.Context, req TransferDetailQueryByOutNoRequest) (resp *TransferDetailQueryResponse, err error)
+	TransferDetailQueryByID(ctx context.Context, req TransferDetailQueryByIDRequest) (resp *TransferDetailQueryResponse, err error)
+	TransferBillReceiptApply(ctx context.Context, req TransferBillReceiptApplyRequest) (resp *TransferBillReceipt, err error)
+	TransferBillReceiptQuery(ctx context.Context, req TransferBillReceiptQueryRequest) (resp *TransferBillReceipt, err error)
+	TransferDetailReceiptApply(ctx context.Context, req TransferDetailReceiptRequest) (resp *TransferDetailReceipt, err error)
+	TransferDetailReceiptQuery(ctx context.Context, req TransferDetailReceiptRequest) (resp *TransferDetailReceipt, err error)
+}
+
+// 余额查询和提现
+type FundService interface {
+	SubMchBalanceQuery(ctx context.Context, req SubMchBalanceQueryRequest) (resp *SubMchBalanceQueryResponse, err error)
+	SubMchEndDayBalanceQuery(ctx context.Context, req SubMchEndDayBalanceQueryRequest) (resp *SubMchEndDayBalanceQueryResponse, err error)
+	PlatformBalanceQuery(ctx context.Context, req PlatformBalanceQueryRequest) (resp *PlatformBalanceQueryResponse, err error)
+	PlatformEndDayBalanceQuery(ctx context.Context, req PlatformEndDayBalanceQueryRequest) (resp *PlatformEndDayBalanceQueryResponse, err error)
+	SubMchWithdraw(ctx context.Context, req SubMchWithdrawRequest) (resp *SubMchWithdrawResponse, err error)
+	SubMchWithdrawQueryByWithdrawID(ctx context.Context, req SubMchWithdrawQueryByWithdrawIDRequest) (resp *SubMchWithdrawQueryResponse, err error)
+	SubMchWithdrawQueryByOutRequestNo(ctx context.Context, req SubMchWithdrawQueryByOutRequestNoRequest) (resp *SubMchWithdrawQueryResponse, err error)
+}
+
+// 商户进件和结算账户
+type ApplymentService interface {
+	ApplymentSubmit(ctx context.Context, req SubmitApplymentRequest) (resp *SubmitApplymentResp, err error)
+	ApplymentQueryByID(ctx context.Context, req QueryApplymentByIDRequest) (resp *ApplymentQueryResponse, err error)
+	ApplymentQueryByOutRequestNo(ctx context.Context, req QueryApplymentByOutRequestNoRequest) (resp *ApplymentQueryResponse, err error)
+	SettlementModify(ctx context.Context, req ModifySettlementRequest) (resp *ModifySettlementResponse, err error)
+	SettlementApplicationQuery(ctx context.Context, req QuerySettlementApplicationRequest) (resp *QuerySettlementApplicationResponse, err error)
+	SettlementQuery(ctx context.Context, req QuerySettlementRequest) (resp *QuerySettlementResponse, err error)
+}
+
+// 银行组件
+type CapitalService interface {
+	SearchBanksByBankAccount(ctx context.Context, req SearchBanksByBankAccountRequest) (resp *SearchBanksByBankAccountResponse, err error)
+	PersonalBankingList(ctx context.Context, req BankListRequest) (resp *BankListResponse, err error)
+	CorporateBankingList(ctx context.Context, req BankListRequest) (resp *BankListResponse, err error)
+	ProvinceList(ctx context.Context) (resp *ProvinceListResponse, err error)
+	CityList(ctx context.Context, req CityListRequest) (resp *CityListResponse, err error)
+	BankBranchList(ctx context.Context, req BankBranchListRequest) (resp *BankBranchListResponse, err error)
+}
+
+// 图片上传
+type MediaService interface {
+	MediaUpload(ctx context.Context, req MediaUploadRequest) (resp *MediaUploadResponse, err error)
+}
+
+// 账单下载
+type BillService interface {
+	DownloadBill(ctx context.Context, info BillDownloadInfo) (body io.ReadCloser, err error)
+}
+
+// 平台证书
+type CertificateService interface {
+	GetCertificates() (resp *GetCertificatesResp, err error)
+}
+
+var _ TransactionService = MerchantApiClient{}
+var _ RefundService = MerchantApiClient{}
+var _ ProfitSharingService = MerchantApiClient{}
+var _ TransferService = MerchantApiClient{}
+var _ FundService = MerchantApiClient{}
+var _ ApplymentService = MerchantApiClient{}
+var _ CapitalService = MerchantApiClient{}
+var _ MediaService = MerchantApiClient{}
+var _ BillService = MerchantApiClient{}
+var _ CertificateService = MerchantApiClient{}
+
+// 所有服务的集合，单元测试时可以替换其中任意一个
+type Services struct {
+	Transactions  TransactionService
+	Refunds       RefundService
+	ProfitSharing ProfitSharingService
+	Transfers     TransferService
+	Funds         FundService
+	Applyments    ApplymentService
+	Capital       CapitalService
+	Media         MediaService
+	Bills         BillService
+	Certificates  CertificateService
+}
+
+// 普通支付服务
+func (c MerchantApiClient) Transactions() TransactionService {
+	return c
+}
+
+// 退款服务
+func (c MerchantApiClient) Refunds() RefundService {
+	return c
+}
+
+// 分账服务
+func (c MerchantApiClient) ProfitSharing() ProfitSharingService {
+	return c
+}
+
+// 批量转账服务
+func (c MerchantApiClient) Transfers() TransferService {
+	return c
+}
+
+// 余额和提现服务
+func (c MerchantApiClient) Funds() FundService {
+	return c
+}
+
+// 进件服务
+func (c MerchantApiClient) Applyments() ApplymentService {
+	return c
+}
+
+// 银行组件服务
+func (c MerchantApiClient) Capital() CapitalService {
+	return c
+}
+
+// 图片上传服务
+func (c MerchantApiClient) Media() MediaService {
+	return c
+}
+
+// 账单下载服务
+func (c MerchantApiClient) Bills() BillService {
+	return c
+}
+
+// 平台证书服务
+func (c MerchantApiClient) Certificates() CertificateService {
+	return c
+}
+
+// 由客户端实现的全部服务
+func (c MerchantApiClient) Services() Services {
+	return Services{
+		Transactions:  c,
+		Refunds:       c,
+		ProfitSharing: c,
+		Transfers:     c,
+		Funds:         c,
+		Applyments:    c,
+		Capital:       c,
+		Media:         c,
+		Bills:         c,
+		Certificates:  c,
+	}
+}
@@ -0,0 +1,26 @@
+package wxmch_api
+
+import "crypto"
+
+/*
+	商户私钥签名和解密
+	私钥可以不放在进程内存中，例如独立的签名进程或者PKCS#11模块，只需实现MerchantSigner
+*/
+
+// 商户私钥签名/解密接口
+// Sign用于请求签名和调起支付签名，digest为SHA256摘要，opts为crypto.SHA256
+// Decrypt用于敏感信息解密，opts为*rsa.OAEPOptions（SHA1）
+type MerchantSigner interface {
+	crypto.Signer
+	crypto.Decrypter
+}
+
+// 默认的本地软件实现，*rsa.PrivateKey本身实现了MerchantSigner
+func NewLocalSigner(apiCert string) (signer MerchantSigner, err error) {
+	priKey, err := buildRSAPrivateKey(apiCert)
+	if err != nil {
+		return
+	}
+	signer = priKey
+	return
+}
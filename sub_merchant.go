@@ -2,7 +2,7 @@ package wxmch_api
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"encoding/json"
 	"fmt"
 )
@@ -81,20 +81,20 @@ type AccountInfo struct {
 
 type ContactInfo struct {
 	// 超级管理员类型
-	ContactType string `json:"contact_type"`
+	ContactType string `json:"contact_type" validate:"required"`
 	// 超级管理员姓名
-	ContactName string `json:"contact_name"`
+	ContactName string `json:"contact_name" validate:"required"`
 	// 超级管理员身份证件号码
-	ContactIDCardNumber string `json:"contact_id_card_number"`
+	ContactIDCardNumber string `json:"contact_id_card_number" validate:"required"`
 	// 超级管理员手机
-	MobilePhone string `json:"mobile_phone"`
+	MobilePhone string `json:"mobile_phone" validate:"required"`
 	// 超级管理员邮箱
 	ContactEmail string `json:"contact_email,omitempty"`
 }
 
 type SalesSceneInfo struct {
 	// 店铺名称
-	StoreName string `json:"store_name"`
+	StoreName string `json:"store_name" validate:"required"`
 	// 店铺链接
 	StoreUrl string `json:"store_url,omitempty"`
 	// 店铺二维码
@@ -105,9 +105,9 @@ type SalesSceneInfo struct {
 
 type SubmitApplymentRequest struct {
 	// 业务申请编号
-	OutRequestNo string `json:"out_request_no"`
+	OutRequestNo string `json:"out_request_no" validate:"required,max=124"`
 	// 主体类型
-	OrganizationType string `json:"organization_type"`
+	OrganizationType string `json:"organization_type" validate:"required"`
 	// 营业执照/登记证书信息
 	BusinessLicenseInfo *BusinessLicenseInfo `json:"business_license_info,omitempty"`
 	// 组织机构代码证信息
@@ -127,7 +127,7 @@ type SubmitApplymentRequest struct {
 	// 店铺信息
 	SalesSceneInfo SalesSceneInfo `json:"sales_scene_info"`
 	// 商户简称
-	MerchantShortname string `json:"merchant_shortname"`
+	MerchantShortname string `json:"merchant_shortname" validate:"required"`
 	// 特殊资质
 	Qualifications []string `json:"qualifications,omitempty"`
 	// 补充材料
@@ -138,10 +138,19 @@ type SubmitApplymentRequest struct {
 
 // 二级商户进件
 func (c MerchantApiClient) ApplymentSubmit(ctx context.Context, req SubmitApplymentRequest) (resp *SubmitApplymentResp, err error) {
-	// 法人身份证姓名和号码需要加密
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	// 加密前复制指针字段，避免修改调用方的请求
 	pubKey := c.getPlatformPublicKey()
-	req.IDCardInfo.IDCardName = encryptCiphertext(req.IDCardInfo.IDCardName, pubKey)
-	req.IDCardInfo.IDCardNumber = encryptCiphertext(req.IDCardInfo.IDCardNumber, pubKey)
+	// 法人身份证姓名和号码需要加密
+	if req.IDCardInfo != nil {
+		idCardInfo := *req.IDCardInfo
+		idCardInfo.IDCardName = encryptCiphertext(idCardInfo.IDCardName, pubKey)
+		idCardInfo.IDCardNumber = encryptCiphertext(idCardInfo.IDCardNumber, pubKey)
+		req.IDCardInfo = &idCardInfo
+	}
 	// 超级管理员姓名、身份证、手机号、邮箱需要加密
 	req.ContactInfo.ContactName = encryptCiphertext(req.ContactInfo.ContactName, pubKey)
 	req.ContactInfo.ContactIDCardNumber = encryptCiphertext(req.ContactInfo.ContactIDCardNumber, pubKey)
@@ -150,16 +159,22 @@ func (c MerchantApiClient) ApplymentSubmit(ctx context.Context, req SubmitApplym
 		req.ContactInfo.ContactEmail = encryptCiphertext(req.ContactInfo.ContactEmail, pubKey)
 	}
 	// 法人其他证件信息（选传）如果有，需要加密
-	if req.IDDocInfo != nil && req.IDDocInfo.IDDocName != "" {
-		req.IDDocInfo.IDDocName = encryptCiphertext(req.IDDocInfo.IDDocName, pubKey)
-	}
-	if req.IDDocInfo != nil && req.IDDocInfo.IDDocNumber != "" {
-		req.IDDocInfo.IDDocNumber = encryptCiphertext(req.IDDocInfo.IDDocNumber, pubKey)
+	if req.IDDocInfo != nil {
+		idDocInfo := *req.IDDocInfo
+		if idDocInfo.IDDocName != "" {
+			idDocInfo.IDDocName = encryptCiphertext(idDocInfo.IDDocName, pubKey)
+		}
+		if idDocInfo.IDDocNumber != "" {
+			idDocInfo.IDDocNumber = encryptCiphertext(idDocInfo.IDDocNumber, pubKey)
+		}
+		req.IDDocInfo = &idDocInfo
 	}
 	// 结算银行账户 如果有，需要加密
-	if req.NeedAccountInfo {
-		req.AccountInfo.AccountName = encryptCiphertext(req.AccountInfo.AccountName, pubKey)
-		req.AccountInfo.AccountNumber = encryptCiphertext(req.AccountInfo.AccountNumber, pubKey)
+	if req.NeedAccountInfo && req.AccountInfo != nil {
+		accountInfo := *req.AccountInfo
+		accountInfo.AccountName = encryptCiphertext(accountInfo.AccountName, pubKey)
+		accountInfo.AccountNumber = encryptCiphertext(accountInfo.AccountNumber, pubKey)
+		req.AccountInfo = &accountInfo
 	}
 
 	body, _ := json.Marshal(&req)
@@ -192,33 +207,9 @@ type ApplymentQueryResponse struct {
 	// 电商平台二级商户号
 	SubMchID string `json:"sub_mchid"`
 	// 汇款账户验证信息
-	AccountValidation struct {
-		// 付款户名
-		AccountName string `json:"account_name"`
-		// 付款卡号
-		AccountNo string `json:"account_no"`
-		// 汇款金额 （以分为单位）
-		PayAmount string `json:"pay_amount"`
-		// 收款卡号
-		DestinationAccountNumber string `json:"destination_account_number"`
-		// 收款户名
-		DestinationAccountName string `json:"destination_account_name"`
-		// 开户银行
-		DestinationAccountBank string `json:"destination_account_bank"`
-		// 省市信息
-		City string `json:"city"`
-		// 备注信息
-		Remark string `json:"remark"`
-		// 汇款截止时间
-		Deadline string `json:"deadline"`
-	} `json:"account_validation"`
+	AccountValidation ApplymentAccountValidation `json:"account_validation"`
 	// 驳回原因详情
-	AuditDetail []struct {
-		// 参数名称
-		ParamName string `json:"param_name"`
-		// 驳回原因
-		RejectReason string `json:"reject_reason"`
-	} `json:"audit_detail"`
+	AuditDetail []ApplymentAuditDetail `json:"audit_detail"`
 	// 法人验证链接
 	LegalValidationUrl string `json:"legal_validation_url"`
 	// 业务申请编号
@@ -227,18 +218,71 @@ type ApplymentQueryResponse struct {
 	ApplymentID uint `json:"applyment_id"`
 }
 
+// 汇款账户验证信息
+type ApplymentAccountValidation struct {
+	// 付款户名
+	AccountName string `json:"account_name"`
+	// 付款卡号
+	AccountNo string `json:"account_no"`
+	// 汇款金额 （以分为单位）
+	PayAmount string `json:"pay_amount"`
+	// 收款卡号
+	DestinationAccountNumber string `json:"destination_account_number"`
+	// 收款户名
+	DestinationAccountName string `json:"destination_account_name"`
+	// 开户银行
+	DestinationAccountBank string `json:"destination_account_bank"`
+	// 省市信息
+	City string `json:"city"`
+	// 备注信息
+	Remark string `json:"remark"`
+	// 汇款截止时间
+	Deadline string `json:"deadline"`
+}
+
+// 驳回原因详情
+type ApplymentAuditDetail struct {
+	// 参数名称
+	ParamName string `json:"param_name"`
+	// 驳回原因
+	RejectReason string `json:"reject_reason"`
+}
+
+// 申请状态
+// 资料校验中
+const ApplymentStateChecking = `CHECKING`
+
+// 待账户验证
 const AccountNeedVerifyState = `ACCOUNT_NEED_VERIFY`
 
+// 审核中
+const ApplymentStateAuditing = `AUDITING`
+
+// 已驳回
+const ApplymentStateRejected = `REJECTED`
+
+// 待签约
+const ApplymentStateNeedSign = `NEED_SIGN`
+
+// 完成
+const ApplymentStateFinish = `FINISH`
+
+// 已冻结
+const ApplymentStateFrozen = `FROZEN`
+
+// 已作废
+const ApplymentStateCanceled = `CANCELED`
+
 // 申请单查询结果脱敏
-func (r *ApplymentQueryResponse) desensitize(priKey *rsa.PrivateKey) (err error) {
+func (r *ApplymentQueryResponse) desensitize(decrypter crypto.Decrypter) (err error) {
 	// -汇款账户验证信息 当申请状态为ACCOUNT_NEED_VERIFY 时有返回，可根据指引汇款，完成账户验证。
 	// 付款户名和付款卡号需要脱敏
 	if r.ApplymentState == AccountNeedVerifyState {
-		r.AccountValidation.AccountName, err = decryptCiphertext(r.AccountValidation.AccountName, priKey)
+		r.AccountValidation.AccountName, err = decryptCiphertext(r.AccountValidation.AccountName, decrypter)
 		if err != nil {
 			return
 		}
-		r.AccountValidation.AccountNo, err = decryptCiphertext(r.AccountValidation.AccountNo, priKey)
+		r.AccountValidation.AccountNo, err = decryptCiphertext(r.AccountValidation.AccountNo, decrypter)
 		if err != nil {
 			return
 		}
@@ -257,7 +301,7 @@ func (c MerchantApiClient) ApplymentQueryByID(ctx context.Context, req QueryAppl
 	if err != nil {
 		return
 	}
-	err = resp.desensitize(c.apiPriKey)
+	err = resp.desensitize(c.signer)
 	if err != nil {
 		return
 	}
@@ -275,7 +319,7 @@ func (c MerchantApiClient) ApplymentQueryByOutRequestNo(ctx context.Context, req
 	if err != nil {
 		return
 	}
-	err = resp.desensitize(c.apiPriKey)
+	err = resp.desensitize(c.signer)
 	if err != nil {
 		return
 	}
@@ -284,29 +328,143 @@ func (c MerchantApiClient) ApplymentQueryByOutRequestNo(ctx context.Context, req
 
 type ModifySettlementRequest struct {
 	// 特约商户号
-	SubMchID string
+	SubMchID string `json:"-"`
+	// 修改模式，为空时使用MODIFY_MODE_ASYNC，返回修改申请单号
+	ModifyMode string `json:"modify_mode,omitempty"`
+	// 账户类型
+	AccountType string `json:"account_type" validate:"required"`
+	// 开户银行
+	AccountBank string `json:"account_bank" validate:"required"`
+	// 开户银行省市编码
+	BankAddressCode string `json:"bank_address_code" validate:"required"`
+	// 开户银行全称（含支行）
+	BankName string `json:"bank_name,omitempty"`
+	// 开户银行联行号
+	BankBranchID string `json:"bank_branch_id,omitempty"`
+	// 银行账号（明文，请求时会加密）
+	AccountNumber string `json:"account_number" validate:"required"`
+	// 开户名称（明文，请求时会加密）
+	AccountName string `json:"account_name,omitempty"`
+}
+
+// 修改结算账户的账户类型
+// 对公银行账户
+const SettlementAccountTypeBusiness = "ACCOUNT_TYPE_BUSINESS"
+
+// 经营者个人银行卡
+const SettlementAccountTypePrivate = "ACCOUNT_TYPE_PRIVATE"
+
+// 修改模式
+// 同步修改，不返回修改申请单号
+const SettlementModifyModeSync = "MODIFY_MODE_SYNC"
+
+// 异步修改，返回修改申请单号，用SettlementApplicationQuery查询审核结果
+const SettlementModifyModeAsync = "MODIFY_MODE_ASYNC"
+
+func (r ModifySettlementRequest) validate() (errs ValidationErrors) {
+	if r.SubMchID == "" {
+		errs.add("/sub_mchid", nil, "不能为空")
+	}
+	switch r.AccountType {
+	case "", SettlementAccountTypeBusiness, SettlementAccountTypePrivate:
+	default:
+		errs.add("/account_type", r.AccountType, "账户类型应为%s或%s", SettlementAccountTypeBusiness, SettlementAccountTypePrivate)
+	}
+	return
+}
+
+type ModifySettlementResponse struct {
+	// 修改结算账户申请单号
+	ApplicationNo string `json:"application_no"`
+}
+
+// 修改结算帐号API
+func (c MerchantApiClient) SettlementModify(ctx context.Context, req ModifySettlementRequest) (resp *ModifySettlementResponse, err error) {
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	if req.ModifyMode == "" {
+		req.ModifyMode = SettlementModifyModeAsync
+	}
+	// 银行账号和开户名称需要加密
+	pubKey := c.getPlatformPublicKey()
+	req.AccountNumber = encryptCiphertext(req.AccountNumber, pubKey)
+	if req.AccountName != "" {
+		req.AccountName = encryptCiphertext(req.AccountName, pubKey)
+	}
+	url := fmt.Sprintf("/v3/apply4sub/sub_merchants/%s/modify-settlement", req.SubMchID)
+	body, _ := json.Marshal(&req)
+	res, err := c.doRequestAndVerifySignature(ctx, "POST", url, nil, body)
+	if err != nil {
+		return
+	}
+	resp = &ModifySettlementResponse{}
+	// 同步修改时没有应答体
+	if len(res) > 0 {
+		err = json.Unmarshal(res, resp)
+	}
+	return
+}
+
+type QuerySettlementApplicationRequest struct {
+	// 特约商户号
+	SubMchID string `validate:"required"`
+	// 修改结算账户申请单号
+	ApplicationNo string `validate:"required"`
+}
+
+// 修改结算账户申请单审核结果
+type SettlementApplicationResult string
+
+// 审核成功
+const SettlementApplicationAuditSuccess SettlementApplicationResult = "AUDIT_SUCCESS"
+
+// 审核中
+const SettlementApplicationAuditing SettlementApplicationResult = "AUDITING"
+
+// 审核驳回
+const SettlementApplicationAuditFail SettlementApplicationResult = "AUDIT_FAIL"
+
+type QuerySettlementApplicationResponse struct {
+	// 开户名称（已解密）
+	AccountName string `json:"account_name"`
 	// 账户类型
 	AccountType string `json:"account_type"`
 	// 开户银行
 	AccountBank string `json:"account_bank"`
-	// 开户银行省市编码
-	BankAddressCode string `json:"bank_address_code"`
 	// 开户银行全称（含支行）
 	BankName string `json:"bank_name"`
 	// 开户银行联行号
 	BankBranchID string `json:"bank_branch_id"`
-	// 银行账号
+	// 银行账号（脱敏）
 	AccountNumber string `json:"account_number"`
+	// 审核结果
+	VerifyResult SettlementApplicationResult `json:"verify_result"`
+	// 审核驳回原因
+	VerifyFailReason string `json:"verify_fail_reason"`
+	// 审核结果更新时间
+	VerifyFinishTime string `json:"verify_finish_time"`
 }
 
-// 修改结算帐号API
-func (c MerchantApiClient) SettlementModify(ctx context.Context, req ModifySettlementRequest) (err error) {
-	url := fmt.Sprintf("/v3/apply4sub/sub_merchants/%s/modify-settlement", req.SubMchID)
-	body, _ := json.Marshal(&req)
-	_, err = c.doRequestAndVerifySignature(ctx, "POST", url, nil, body)
+// 查询结算账户修改申请状态API
+func (c MerchantApiClient) SettlementApplicationQuery(ctx context.Context, req QuerySettlementApplicationRequest) (resp *QuerySettlementApplicationResponse, err error) {
+	err = Validate(req)
 	if err != nil {
 		return
 	}
+	url := fmt.Sprintf("/v3/apply4sub/sub_merchants/%s/application/%s", req.SubMchID, req.ApplicationNo)
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", url, nil, nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	if err != nil {
+		return
+	}
+	if resp.AccountName != "" {
+		resp.AccountName, err = decryptCiphertext(resp.AccountName, c.signer)
+	}
 	return
 }
 
@@ -315,6 +473,18 @@ type QuerySettlementRequest struct {
 	SubMchID string
 }
 
+// 结算账户汇款验证结果
+type SettlementVerifyResult string
+
+// 验证成功，该账户可正常发起提现
+const SettlementVerifySuccess SettlementVerifyResult = "VERIFY_SUCCESS"
+
+// 验证失败，该账户无法发起提现，需要检查并修改
+const SettlementVerifyFail SettlementVerifyResult = "VERIFY_FAIL"
+
+// 验证中，商户可发起提现尝试
+const SettlementVerifying SettlementVerifyResult = "VERIFYING"
+
 type QuerySettlementResponse struct {
 	// 账户类型
 	AccountType string `json:"account_type"`
@@ -327,7 +497,9 @@ type QuerySettlementResponse struct {
 	// 银行账号
 	AccountNumber string `json:"account_number"`
 	// 汇款验证结果
-	VerifyResult string `json:"verify_result"`
+	VerifyResult SettlementVerifyResult `json:"verify_result"`
+	// 汇款验证失败原因
+	VerifyFailReason string `json:"verify_fail_reason"`
 }
 
 // 查询结算账户API
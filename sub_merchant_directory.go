@@ -0,0 +1,236 @@
+package wxmch_api
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+	二级商户目录
+	按二级商户号保存appid、通知地址、是否分账等默认配置，
+	client.SubMerchant(subMchID)返回的句柄会自动填充请求中的SubMchID、SubAppID、SpAppID、SpMchID
+*/
+
+// 二级商户默认配置
+type SubMerchantConfig struct {
+	// 二级商户号
+	SubMchID string
+	// 二级商户公众号ID
+	SubAppID string
+	// 服务商公众号ID
+	SpAppID string
+	// 服务商户号，为空时使用客户端的商户号
+	SpMchID string
+	// 支付结果通知地址
+	NotifyUrl string
+	// 退款结果通知地址
+	RefundNotifyUrl string
+	// 下单时默认指定分账
+	ProfitSharing bool
+}
+
+type SubMerchantDirectory struct {
+	mu      sync.RWMutex
+	configs map[string]SubMerchantConfig
+}
+
+func NewSubMerchantDirectory(configs ...SubMerchantConfig) *SubMerchantDirectory {
+	d := &SubMerchantDirectory{configs: make(map[string]SubMerchantConfig)}
+	for _, cfg := range configs {
+		d.Set(cfg)
+	}
+	return d
+}
+
+// 保存二级商户配置，相同二级商户号会被覆盖
+func (d *SubMerchantDirectory) Set(cfg SubMerchantConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.configs[cfg.SubMchID] = cfg
+}
+
+// 获取二级商户配置
+func (d *SubMerchantDirectory) Get(subMchID string) (cfg SubMerchantConfig, ok bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	cfg, ok = d.configs[subMchID]
+	return
+}
+
+// 删除二级商户配置
+func (d *SubMerchantDirectory) Remove(subMchID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.configs, subMchID)
+}
+
+// 设置二级商户目录，返回新的客户端
+func (c MerchantApiClient) WithSubMerchantDirectory(d *SubMerchantDirectory) MerchantApiClient {
+	c.subMerchants = d
+	return c
+}
+
+// 二级商户句柄，目录中没有该二级商户时只填充SubMchID和SpMchID
+func (c MerchantApiClient) SubMerchant(subMchID string) SubMerchantClient {
+	cfg := SubMerchantConfig{SubMchID: subMchID}
+	if c.subMerchants != nil {
+		if found, ok := c.subMerchants.Get(subMchID); ok {
+			cfg = found
+		}
+	}
+	if cfg.SpMchID == "" {
+		cfg.SpMchID = c.mchId
+	}
+	return SubMerchantClient{client: c, config: cfg}
+}
+
+// 限定在一个二级商户下的API
+type SubMerchantClient struct {
+	client MerchantApiClient
+	config SubMerchantConfig
+}
+
+// 二级商户配置
+func (s SubMerchantClient) Config() SubMerchantConfig {
+	return s.config
+}
+
+// 请求中的值为空时使用默认值
+func fillDefault(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// JSAPI下单API
+func (s SubMerchantClient) JsApiPrepay(ctx context.Context, req JsApiPrepayRequest) (resp *PrepayPayResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	fillDefault(&req.SpMchID, s.config.SpMchID)
+	fillDefault(&req.SpAppID, s.config.SpAppID)
+	fillDefault(&req.SubAppID, s.config.SubAppID)
+	fillDefault(&req.NotifyUrl, s.config.NotifyUrl)
+	if s.config.ProfitSharing {
+		req.SettleInfo.ProfitSharing = true
+	}
+	resp, err = s.client.JsApiPrepay(ctx, req)
+	return
+}
+
+// 微信支付订单号查询交易结果
+func (s SubMerchantClient) PayResultQueryByTransactionID(ctx context.Context, req QueryPayResultByTransactionIDRequest) (resp *QueryPayResultResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	fillDefault(&req.SpMchID, s.config.SpMchID)
+	resp, err = s.client.PayResultQueryByTransactionID(ctx, req)
+	return
+}
+
+// 商户订单号查询交易结果
+func (s SubMerchantClient) PayResultQueryByOutRequestNo(ctx context.Context, req QueryPayResultByOutRequestNoRequest) (resp *QueryPayResultResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	fillDefault(&req.SpMchID, s.config.SpMchID)
+	resp, err = s.client.PayResultQueryByOutRequestNo(ctx, req)
+	return
+}
+
+// 关闭订单
+func (s SubMerchantClient) Close(ctx context.Context, req CloseOrderRequest) (err error) {
+	req.SubMchID = s.config.SubMchID
+	fillDefault(&req.SpMchID, s.config.SpMchID)
+	err = s.client.Close(ctx, req)
+	return
+}
+
+// 申请退款
+func (s SubMerchantClient) RefundApply(ctx context.Context, req RefundRequest) (resp *RefundResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	fillDefault(&req.SpAppID, s.config.SpAppID)
+	fillDefault(&req.SubAppID, s.config.SubAppID)
+	fillDefault(&req.NotifyUrl, s.config.RefundNotifyUrl)
+	resp, err = s.client.RefundApply(ctx, req)
+	return
+}
+
+// 通过微信支付退款单号查询退款
+func (s SubMerchantClient) QueryRefundByID(ctx context.Context, req QueryRefundByIDRequest) (resp *QueryRefundResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.QueryRefundByID(ctx, req)
+	return
+}
+
+// 通过商户退款单号查询退款
+func (s SubMerchantClient) QueryRefundByOutRefundNo(ctx context.Context, req QueryRefundByOutRefundNoRequest) (resp *QueryRefundResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.QueryRefundByOutRefundNo(ctx, req)
+	return
+}
+
+// 请求分账API
+func (s SubMerchantClient) ProfitShareApply(ctx context.Context, req ProfitShareApplyRequest) (resp *ProfitShareApplyResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	fillDefault(&req.SpAppID, s.config.SpAppID)
+	resp, err = s.client.ProfitShareApply(ctx, req)
+	return
+}
+
+// 查询分账结果API
+func (s SubMerchantClient) ProfitShareQuery(ctx context.Context, req ProfitShareQueryRequest) (resp *ProfitShareQueryResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.ProfitShareQuery(ctx, req)
+	return
+}
+
+// 请求分账回退API
+func (s SubMerchantClient) ProfitReturnApply(ctx context.Context, req ProfitReturnApplyRequest) (resp *ProfitReturnApplyResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.ProfitReturnApply(ctx, req)
+	return
+}
+
+// 查询分账回退结果API
+func (s SubMerchantClient) ProfitReturnQuery(ctx context.Context, req ProfitReturnQueryRequest) (resp ProfitReturnQueryResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.ProfitReturnQuery(ctx, req)
+	return
+}
+
+// 完结分账API
+func (s SubMerchantClient) ProfitShareFinish(ctx context.Context, req ProfitShareFinishRequest) (resp *ProfitShareFinishResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.ProfitShareFinish(ctx, req)
+	return
+}
+
+// 二级商户账户实时余额查询
+func (s SubMerchantClient) BalanceQuery(ctx context.Context, req SubMchBalanceQueryRequest) (resp *SubMchBalanceQueryResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.SubMchBalanceQuery(ctx, req)
+	return
+}
+
+// 二级商户账户日终余额
+func (s SubMerchantClient) EndDayBalanceQuery(ctx context.Context, req SubMchEndDayBalanceQueryRequest) (resp *SubMchEndDayBalanceQueryResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.SubMchEndDayBalanceQuery(ctx, req)
+	return
+}
+
+// 二级商户提现
+func (s SubMerchantClient) Withdraw(ctx context.Context, req SubMchWithdrawRequest) (resp *SubMchWithdrawResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.SubMchWithdraw(ctx, req)
+	return
+}
+
+// 根据微信提现单号查询二级商户提现状态
+func (s SubMerchantClient) WithdrawQueryByWithdrawID(ctx context.Context, req SubMchWithdrawQueryByWithdrawIDRequest) (resp *SubMchWithdrawQueryResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.SubMchWithdrawQueryByWithdrawID(ctx, req)
+	return
+}
+
+// 根据商户提现单号查询二级商户提现状态
+func (s SubMerchantClient) WithdrawQueryByOutRequestNo(ctx context.Context, req SubMchWithdrawQueryByOutRequestNoRequest) (resp *SubMchWithdrawQueryResponse, err error) {
+	req.SubMchID = s.config.SubMchID
+	resp, err = s.client.SubMchWithdrawQueryByOutRequestNo(ctx, req)
+	return
+}
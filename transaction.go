@@ -12,23 +12,23 @@ import (
 
 type JsApiPrepayRequest struct {
 	// 服务商公众号ID
-	SpAppID string `json:"sp_appid"`
+	SpAppID string `json:"sp_appid" validate:"required"`
 	// 服务商户号
-	SpMchID string `json:"sp_mchid"`
+	SpMchID string `json:"sp_mchid" validate:"required"`
 	// 二级商户公众号ID
 	SubAppID string `json:"sub_appid"`
 	// 二级商户号
-	SubMchID string `json:"sub_mchid"`
+	SubMchID string `json:"sub_mchid" validate:"required"`
 	// 商品描述
-	Description string `json:"description"`
+	Description string `json:"description" validate:"required,max=127"`
 	// 商户订单号
-	OutTradeNo string `json:"out_trade_no"`
+	OutTradeNo string `json:"out_trade_no" validate:"required,min=6,max=32"`
 	// 交易结束时间
 	TimeExpire string `json:"time_expire"`
 	// 附加数据
-	Attach string `json:"attach"`
+	Attach string `json:"attach" validate:"max=128"`
 	// 通知地址
-	NotifyUrl string `json:"notify_url"`
+	NotifyUrl string `json:"notify_url" validate:"required,max=256"`
 	// 订单优惠标记
 	GoodsTag string `json:"goods_tag"`
 	// 结算信息
@@ -41,7 +41,7 @@ type JsApiPrepayRequest struct {
 	// 订单金额
 	Amount struct {
 		// 总金额
-		Total uint `json:"total"`
+		Total uint `json:"total" validate:"required"`
 		// 货币类型
 		Currency string `json:"currency"`
 	} `json:"amount"`
@@ -123,9 +123,23 @@ type JsApiPayResponse struct {
 	PaySign string
 }
 
+func (r JsApiPrepayRequest) validate() (errs ValidationErrors) {
+	if r.Payer.SpOpenID == "" && r.Payer.SubOpenID == "" {
+		errs.add("/payer/sp_openid", r.Payer.SpOpenID, "sp_openid和sub_openid不能同时为空")
+	}
+	if r.Payer.SubOpenID != "" && r.SubAppID == "" {
+		errs.add("/sub_appid", r.SubAppID, "传入sub_openid时sub_appid不能为空")
+	}
+	return
+}
+
 // JSAPI下单API
 func (c MerchantApiClient) JsApiPrepay(ctx context.Context, req JsApiPrepayRequest) (resp *PrepayPayResponse, err error) {
 	url := "/v3/pay/partner/transactions/jsapi"
+	err = Validate(req)
+	if err != nil {
+		return
+	}
 	body, _ := json.Marshal(&req)
 	res, err := c.doRequestAndVerifySignature(ctx, "POST", url, nil, body)
 	if err != nil {
@@ -269,7 +283,7 @@ func (c MerchantApiClient) PayResultQueryByOutRequestNo(ctx context.Context, req
 // 生成JSAPI调起起支付的request结构体
 func (c MerchantApiClient) GenJsApiPayRequest(req JsApiPayRequest) (resp *JsApiPayResponse, err error) {
 	nonce := RandStringBytesMaskImprSrc(10)
-	paySign, err := createPaySign(c.apiPriKey, req.AppID, req.TimeStamp, nonce, req.Package)
+	paySign, err := createPaySign(c.signer, req.AppID, req.TimeStamp, nonce, req.Package)
 	if err != nil {
 		return
 	}
@@ -13,29 +13,29 @@ import (
 
 type BatchTransferRequest struct {
 	// 直连商户的AppID
-	AppID string `json:"appid"`
+	AppID string `json:"appid" validate:"required"`
 	// 商家批次单号
-	OutBatchNo string `json:"out_batch_no"`
+	OutBatchNo string `json:"out_batch_no" validate:"required,min=5,max=32"`
 	// 批次名称
-	BatchName string `json:"batch_name"`
+	BatchName string `json:"batch_name" validate:"required,max=32"`
 	// 批次备注
-	BatchRemark string `json:"batch_remark"`
+	BatchRemark string `json:"batch_remark" validate:"required,max=32"`
 	// 转账总金额
-	TotalAmount uint `json:"total_amount"`
+	TotalAmount uint `json:"total_amount" validate:"required"`
 	// 转账总笔数
-	TotalNum           uint             `json:"total_num"`
-	TransferDetailList []TransferDetail `json:"transfer_detail_list"`
+	TotalNum           uint             `json:"total_num" validate:"required"`
+	TransferDetailList []TransferDetail `json:"transfer_detail_list" validate:"required,max=3000"`
 }
 
 type TransferDetail struct {
 	// 商家明细单号
-	OutDetailNo string `json:"out_detail_no"`
+	OutDetailNo string `json:"out_detail_no" validate:"required,max=32"`
 	// 转账金额
-	TransferAmount uint `json:"transfer_amount"`
+	TransferAmount uint `json:"transfer_amount" validate:"required"`
 	// 转账备注
-	TransferRemark string `json:"transfer_remark"`
+	TransferRemark string `json:"transfer_remark" validate:"required,max=32"`
 	// OpenID
-	OpenID string `json:"openid"`
+	OpenID string `json:"openid" validate:"required,max=128"`
 	// 收款用户姓名
 	UserName string `json:"user_name"`
 	// 收款用户身份证
@@ -51,9 +51,58 @@ type BatchTransferResponse struct {
 	CreateTime string `json:"create_time"`
 }
 
+// 单个批次最多的转账明细数
+const MaxTransferDetailNum = 3000
+
+// 查询批次单时每页最多的明细数
+const MaxTransferDetailPageLimit = 100
+
+// 转账批次状态
+// 已受理
+const TransferBatchStatusAccepted = "ACCEPTED"
+
+// 转账中
+const TransferBatchStatusProcessing = "PROCESSING"
+
+// 已完成
+const TransferBatchStatusFinished = "FINISHED"
+
+// 已关闭
+const TransferBatchStatusClosed = "CLOSED"
+
+// 转账明细状态
+// 查询全部明细
+const TransferDetailStatusAll = "ALL"
+
+// 转账成功
+const TransferDetailStatusSuccess = "SUCCESS"
+
+// 转账失败
+const TransferDetailStatusFail = "FAIL"
+
+func (r BatchTransferRequest) validate() (errs ValidationErrors) {
+	var totalAmount uint
+	for _, d := range r.TransferDetailList {
+		totalAmount += d.TransferAmount
+	}
+	if r.TotalNum != uint(len(r.TransferDetailList)) {
+		errs.add("/total_num", r.TotalNum, "转账总笔数与明细数量%d不一致", len(r.TransferDetailList))
+	}
+	if r.TotalAmount != totalAmount {
+		errs.add("/total_amount", r.TotalAmount, "转账总金额与明细金额合计%d不一致", totalAmount)
+	}
+	return
+}
+
 func (c MerchantApiClient) BatchTransfer(ctx context.Context, req BatchTransferRequest) (resp *BatchTransferResponse, err error) {
 	url := "/v3/transfer/batches"
+	err = Validate(req)
+	if err != nil {
+		return
+	}
 	pubKey := c.getPlatformPublicKey()
+	// 复制明细，避免加密后修改调用方的数据
+	req.TransferDetailList = append([]TransferDetail(nil), req.TransferDetailList...)
 	for i := range req.TransferDetailList {
 		req.TransferDetailList[i].UserName = encryptCiphertext(req.TransferDetailList[i].UserName, pubKey)
 		if req.TransferDetailList[i].UserIDCard != "" {
@@ -75,7 +124,18 @@ type BatchTransferQueryByOutNoRequest struct {
 	NeedQueryDetail bool   `json:"need_query_detail"`
 	Offset          int64  `json:"offset,omitempty"`
 	Limit           int64  `json:"limit,omitempty"`
-	DetailStatus    string `json:"detail_status,omitempty"`
+	// 明细状态，ALL、SUCCESS、FAIL，为空时不传
+	DetailStatus string `json:"detail_status,omitempty"`
+}
+
+type BatchTransferQueryByIDRequest struct {
+	// 微信批次单号
+	BatchID         string `json:"batch_id"`
+	NeedQueryDetail bool   `json:"need_query_detail"`
+	Offset          int64  `json:"offset,omitempty"`
+	Limit           int64  `json:"limit,omitempty"`
+	// 明细状态，ALL、SUCCESS、FAIL，为空时不传
+	DetailStatus string `json:"detail_status,omitempty"`
 }
 
 type TransferBatch struct {
@@ -128,14 +188,39 @@ type BatchTransferQueryByOutNoResponse struct {
 	Limit           int64                `json:"limit"`
 }
 
+// 批次单查询参数，只在查询明细时传分页和明细状态
+func batchTransferQueryParams(needQueryDetail bool, offset int64, limit int64, detailStatus string) map[string]string {
+	qm := map[string]string{"need_query_detail": strconv.FormatBool(needQueryDetail)}
+	if !needQueryDetail {
+		return qm
+	}
+	qm["offset"] = strconv.FormatInt(offset, 10)
+	if limit > 0 {
+		qm["limit"] = strconv.FormatInt(limit, 10)
+	}
+	if detailStatus != "" {
+		qm["detail_status"] = detailStatus
+	}
+	return qm
+}
+
 func (c MerchantApiClient) BatchTransferQueryByOutNo(ctx context.Context, req BatchTransferQueryByOutNoRequest) (resp *BatchTransferQueryByOutNoResponse, err error) {
 	url := fmt.Sprintf("/v3/transfer/batches/out-batch-no/%s", req.OutBatchNo)
-	qm := map[string]string{
-		"need_query_detail": strconv.FormatBool(req.NeedQueryDetail),
-		"offset":            strconv.FormatInt(req.Offset, 10),
-		"limit":             strconv.FormatInt(req.Limit, 10),
-		"detail_status":     req.DetailStatus,
+	qm := batchTransferQueryParams(req.NeedQueryDetail, req.Offset, req.Limit, req.DetailStatus)
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", url, qm, nil)
+	if err != nil {
+		return
 	}
+	err = json.Unmarshal(res, &resp)
+	return
+}
+
+// 微信批次单号查询批次单，应答与商家批次单号查询相同
+type BatchTransferQueryByIDResponse = BatchTransferQueryByOutNoResponse
+
+func (c MerchantApiClient) BatchTransferQueryByID(ctx context.Context, req BatchTransferQueryByIDRequest) (resp *BatchTransferQueryByIDResponse, err error) {
+	url := fmt.Sprintf("/v3/transfer/batches/batch-id/%s", req.BatchID)
+	qm := batchTransferQueryParams(req.NeedQueryDetail, req.Offset, req.Limit, req.DetailStatus)
 	res, err := c.doRequestAndVerifySignature(ctx, "GET", url, qm, nil)
 	if err != nil {
 		return
@@ -143,3 +228,193 @@ func (c MerchantApiClient) BatchTransferQueryByOutNo(ctx context.Context, req Ba
 	err = json.Unmarshal(res, &resp)
 	return
 }
+
+type TransferDetailQueryByOutNoRequest struct {
+	// 商家批次单号
+	OutBatchNo string `json:"out_batch_no" validate:"required,min=5,max=32"`
+	// 商家明细单号
+	OutDetailNo string `json:"out_detail_no" validate:"required,min=5,max=32"`
+}
+
+type TransferDetailQueryByIDRequest struct {
+	// 微信批次单号
+	BatchID string `json:"batch_id" validate:"required,min=32,max=64"`
+	// 微信明细单号
+	DetailID string `json:"detail_id" validate:"required,min=32,max=64"`
+}
+
+type TransferDetailQueryResponse struct {
+	// 直连商户号
+	MchID string `json:"mchid"`
+	// 商家批次单号
+	OutBatchNo string `json:"out_batch_no"`
+	// 微信批次单号
+	BatchID string `json:"batch_id"`
+	// 直连商户AppID
+	AppID string `json:"appid"`
+	// 商家明细单号
+	OutDetailNo string `json:"out_detail_no"`
+	// 微信明细单号
+	DetailID string `json:"detail_id"`
+	// 明细状态，INIT、WAIT_PAY、PROCESSING、SUCCESS、FAIL
+	DetailStatus string `json:"detail_status"`
+	// 转账金额
+	TransferAmount int64 `json:"transfer_amount"`
+	// 转账备注
+	TransferRemark string `json:"transfer_remark"`
+	// 明细失败原因
+	FailReason string `json:"fail_reason"`
+	// OpenID
+	OpenID string `json:"openid"`
+	// 收款用户姓名（已解密）
+	UserName string `json:"user_name"`
+	// 转账发起时间
+	InitiateTime string `json:"initiate_time"`
+	// 明细更新时间
+	UpdateTime string `json:"update_time"`
+}
+
+// 商家明细单号查询明细单
+func (c MerchantApiClient) TransferDetailQueryByOutNo(ctx context.Context, req TransferDetailQueryByOutNoRequest) (resp *TransferDetailQueryResponse, err error) {
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	url := fmt.Sprintf("/v3/transfer/batches/out-batch-no/%s/details/out-detail-no/%s", req.OutBatchNo, req.OutDetailNo)
+	resp, err = c.transferDetailQuery(ctx, url)
+	return
+}
+
+// 微信明细单号查询明细单
+func (c MerchantApiClient) TransferDetailQueryByID(ctx context.Context, req TransferDetailQueryByIDRequest) (resp *TransferDetailQueryResponse, err error) {
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	url := fmt.Sprintf("/v3/transfer/batches/batch-id/%s/details/detail-id/%s", req.BatchID, req.DetailID)
+	resp, err = c.transferDetailQuery(ctx, url)
+	return
+}
+
+func (c MerchantApiClient) transferDetailQuery(ctx context.Context, url string) (resp *TransferDetailQueryResponse, err error) {
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", url, nil, nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	if err != nil {
+		return
+	}
+	if resp.UserName != "" {
+		resp.UserName, err = decryptCiphertext(resp.UserName, c.signer)
+	}
+	return
+}
+
+// 批次中转账明细的迭代器，自动翻页
+type TransferDetailIterator struct {
+	pager offsetPager
+	buf   []TransferDetailItem
+	batch *TransferBatch
+}
+
+// 按商家批次单号遍历明细，detailStatus为ALL、SUCCESS或FAIL，为空时遍历全部明细
+func NewTransferDetailIterator(s TransferService, outBatchNo string, detailStatus string) *TransferDetailIterator {
+	detailStatus = normalizeTransferDetailStatus(detailStatus)
+	return newTransferDetailIterator(detailStatus, func(ctx context.Context, offset int, limit int) (*BatchTransferQueryByOutNoResponse, error) {
+		return s.BatchTransferQueryByOutNo(ctx, BatchTransferQueryByOutNoRequest{
+			OutBatchNo:      outBatchNo,
+			NeedQueryDetail: true,
+			Offset:          int64(offset),
+			Limit:           int64(limit),
+			DetailStatus:    detailStatus,
+		})
+	})
+}
+
+// 按微信批次单号遍历明细，detailStatus为ALL、SUCCESS或FAIL，为空时遍历全部明细
+func NewTransferDetailIteratorByBatchID(s TransferService, batchID string, detailStatus string) *TransferDetailIterator {
+	detailStatus = normalizeTransferDetailStatus(detailStatus)
+	return newTransferDetailIterator(detailStatus, func(ctx context.Context, offset int, limit int) (*BatchTransferQueryByOutNoResponse, error) {
+		return s.BatchTransferQueryByID(ctx, BatchTransferQueryByIDRequest{
+			BatchID:         batchID,
+			NeedQueryDetail: true,
+			Offset:          int64(offset),
+			Limit:           int64(limit),
+			DetailStatus:    detailStatus,
+		})
+	})
+}
+
+// 明细状态为空时查询全部明细
+func normalizeTransferDetailStatus(detailStatus string) string {
+	if detailStatus == "" {
+		return TransferDetailStatusAll
+	}
+	return detailStatus
+}
+
+func newTransferDetailIterator(detailStatus string, query func(ctx context.Context, offset int, limit int) (*BatchTransferQueryByOutNoResponse, error)) *TransferDetailIterator {
+	it := &TransferDetailIterator{}
+	it.pager = offsetPager{
+		limit: MaxTransferDetailPageLimit,
+		fetch: func(ctx context.Context, offset int, limit int) (n int, total int, err error) {
+			resp, err := query(ctx, offset, limit)
+			if err != nil {
+				return
+			}
+			batch := resp.TransferBatch
+			it.batch = &batch
+			// 按明细状态过滤
+			for _, item := range resp.TransferDetails {
+				if detailStatus == TransferDetailStatusAll || item.Status == detailStatus {
+					it.buf = append(it.buf, item)
+				}
+			}
+			n = len(resp.TransferDetails)
+			switch detailStatus {
+			case TransferDetailStatusSuccess:
+				total = int(batch.SuccessNum)
+			case TransferDetailStatusFail:
+				total = int(batch.FailNum)
+			default:
+				total = int(batch.TotalNum)
+			}
+			return
+		},
+	}
+	return it
+}
+
+// 下一条明细，遍历结束时返回ErrIteratorDone
+func (it *TransferDetailIterator) Next(ctx context.Context) (item *TransferDetailItem, err error) {
+	for len(it.buf) == 0 {
+		err = it.pager.nextPage(ctx)
+		if err != nil {
+			return
+		}
+	}
+	item = &it.buf[0]
+	it.buf = it.buf[1:]
+	return
+}
+
+// 剩余的全部明细
+func (it *TransferDetailIterator) All(ctx context.Context) (items []TransferDetailItem, err error) {
+	for {
+		item, nextErr := it.Next(ctx)
+		if nextErr == ErrIteratorDone {
+			return
+		}
+		if nextErr != nil {
+			err = nextErr
+			return
+		}
+		items = append(items, *item)
+	}
+}
+
+// 最近一次查询到的批次信息，还没有查询时为nil
+func (it *TransferDetailIterator) Batch() *TransferBatch {
+	return it.batch
+}
@@ -0,0 +1,477 @@
+package wxmch_api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+	批量转账到零钱的批次拆分和跟踪
+	任意数量的转账明细按单批次上限拆分，商家批次单号由付款单号加序号生成，自动计算每个批次的总金额和总笔数；
+	提交失败时用相同的批次单号重试，批次已存在时按已提交处理；被拒绝或重试次数用完的批次不再提交，其中的明细按失败处理。
+	所有批次处理完成后查询成功和失败的明细，汇总为一个结果。
+	重复执行同一个付款单号会从上次中断的位置继续，明细需要与第一次执行时相同
+*/
+
+const defaultTransferPayoutPollInterval = 10 * time.Second
+
+const defaultTransferPayoutMaxAttempts = 3
+
+const defaultTransferPayoutRetryInterval = 2 * time.Second
+
+// 批次单号中序号的位数，付款单号最长32-3位
+const transferPayoutSeqWidth = 3
+
+// 付款状态
+type TransferPayoutStatus string
+
+// 进行中
+const TransferPayoutRunning TransferPayoutStatus = "RUNNING"
+
+// 所有批次处理完成
+const TransferPayoutFinished TransferPayoutStatus = "FINISHED"
+
+// 批次被微信支付拒绝或重试次数用完仍未提交成功，批次中的明细都按失败处理
+const TransferPayoutBatchRejected = "REJECTED"
+
+var ErrTransferPayoutChanged = errors.New("转账明细与已保存的付款不一致")
+
+type TransferPayoutRequest struct {
+	// 直连商户的AppID
+	AppID string `json:"appid" validate:"required"`
+	// 付款单号，批次单号为付款单号加3位序号
+	PayoutNo string `json:"payout_no" validate:"required,min=2,max=29"`
+	// 批次名称
+	BatchName string `json:"batch_name" validate:"required,max=32"`
+	// 批次备注
+	BatchRemark string `json:"batch_remark" validate:"required,max=32"`
+	// 每个批次的明细数，为0时使用MaxTransferDetailNum
+	BatchSize int `json:"batch_size" validate:"max=3000"`
+	// 转账明细
+	Details []TransferDetail `json:"details" validate:"required"`
+}
+
+func (r TransferPayoutRequest) validate() (errs ValidationErrors) {
+	seen := make(map[string]bool, len(r.Details))
+	for i, d := range r.Details {
+		if seen[d.OutDetailNo] {
+			errs.add(fmt.Sprintf("/details/%d/out_detail_no", i), d.OutDetailNo, "商家明细单号重复")
+		}
+		seen[d.OutDetailNo] = true
+	}
+	if r.BatchSize < 0 {
+		errs.add("/batch_size", r.BatchSize, "批次明细数不能小于0")
+	} else if n := len(r.batches()); n >= 1000 {
+		errs.add("/details", len(r.Details), "拆分后的批次数%d超过999", n)
+	}
+	return
+}
+
+// 按批次拆分明细
+func (r TransferPayoutRequest) batches() (batches [][]TransferDetail) {
+	size := r.BatchSize
+	if size <= 0 {
+		size = MaxTransferDetailNum
+	}
+	for start := 0; start < len(r.Details); start += size {
+		end := start + size
+		if end > len(r.Details) {
+			end = len(r.Details)
+		}
+		batches = append(batches, r.Details[start:end])
+	}
+	return
+}
+
+// 一个转账批次
+type TransferPayoutBatch struct {
+	// 商家批次单号
+	OutBatchNo string `json:"out_batch_no"`
+	// 微信批次单号
+	BatchID string `json:"batch_id"`
+	// 转账总金额
+	TotalAmount uint `json:"total_amount"`
+	// 转账总笔数
+	TotalNum uint `json:"total_num"`
+	// 批次状态，未提交时为空，提交失败时为TransferPayoutBatchRejected
+	BatchStatus string `json:"batch_status"`
+	// 批次关闭原因，批次被拒绝时为错误码
+	CloseReason string `json:"close_reason,omitempty"`
+	// 提交次数
+	Attempts int `json:"attempts"`
+	// 提交或查询失败原因
+	Error string `json:"error,omitempty"`
+	// 更新时间
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 批次是否处理完成
+func (b TransferPayoutBatch) Done() bool {
+	return b.BatchStatus == TransferBatchStatusFinished || b.BatchStatus == TransferBatchStatusClosed || b.BatchStatus == TransferPayoutBatchRejected
+}
+
+// 一笔转账明细的结果
+type TransferPayoutDetail struct {
+	// 商家批次单号
+	OutBatchNo string `json:"out_batch_no"`
+	// 商家明细单号
+	OutDetailNo string `json:"out_detail_no"`
+	// 微信明细单号
+	DetailID string `json:"detail_id"`
+	// 转账金额
+	Amount uint `json:"amount"`
+	// 明细状态
+	Status string `json:"detail_status"`
+	// 失败原因，批次被拒绝时为错误码，批次关闭时为关闭原因
+	FailReason string `json:"fail_reason,omitempty"`
+}
+
+// 付款状态
+type TransferPayoutState struct {
+	// 付款单号
+	PayoutNo string `json:"payout_no"`
+	// 付款状态
+	Status TransferPayoutStatus `json:"status"`
+	// 转账批次
+	Batches []TransferPayoutBatch `json:"batches"`
+	// 转账成功的明细，所有批次处理完成后查询
+	Succeeded []TransferPayoutDetail `json:"succeeded,omitempty"`
+	// 转账失败的明细，包括已关闭和被拒绝批次中的明细
+	Failed []TransferPayoutDetail `json:"failed,omitempty"`
+	// 创建时间
+	CreatedAt time.Time `json:"created_at"`
+	// 更新时间
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 转账总金额
+func (s TransferPayoutState) TotalAmount() (amount uint) {
+	for _, b := range s.Batches {
+		amount += b.TotalAmount
+	}
+	return
+}
+
+// 转账成功的总金额
+func (s TransferPayoutState) SuccessAmount() (amount uint) {
+	for _, d := range s.Succeeded {
+		amount += d.Amount
+	}
+	return
+}
+
+// 转账失败的总金额
+func (s TransferPayoutState) FailAmount() (amount uint) {
+	for _, d := range s.Failed {
+		amount += d.Amount
+	}
+	return
+}
+
+// 付款的存储
+type TransferPayoutStore interface {
+	Save(state TransferPayoutState) (err error)
+	// 付款不存在时返回nil
+	Load(payoutNo string) (state *TransferPayoutState, err error)
+}
+
+type memoryTransferPayoutStore struct {
+	mu     sync.RWMutex
+	states map[string]TransferPayoutState
+}
+
+func NewMemoryTransferPayoutStore() TransferPayoutStore {
+	return &memoryTransferPayoutStore{states: make(map[string]TransferPayoutState)}
+}
+
+func (s *memoryTransferPayoutStore) Save(state TransferPayoutState) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state.Batches = append([]TransferPayoutBatch(nil), state.Batches...)
+	s.states[state.PayoutNo] = state
+	return
+}
+
+func (s *memoryTransferPayoutStore) Load(payoutNo string) (state *TransferPayoutState, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if st, ok := s.states[payoutNo]; ok {
+		st.Batches = append([]TransferPayoutBatch(nil), st.Batches...)
+		state = &st
+	}
+	return
+}
+
+type TransferPayout struct {
+	transfers TransferService
+	store     TransferPayoutStore
+	// 查询批次状态的间隔
+	PollInterval time.Duration
+	// 每个批次最多提交次数
+	MaxAttempts int
+	// 重试间隔，第n次重试等待RetryInterval*2^(n-1)
+	RetryInterval time.Duration
+}
+
+func NewTransferPayout(transfers TransferService, store TransferPayoutStore) *TransferPayout {
+	return &TransferPayout{
+		transfers:     transfers,
+		store:         store,
+		PollInterval:  defaultTransferPayoutPollInterval,
+		MaxAttempts:   defaultTransferPayoutMaxAttempts,
+		RetryInterval: defaultTransferPayoutRetryInterval,
+	}
+}
+
+// 付款的当前状态，不存在时返回nil
+func (p *TransferPayout) State(payoutNo string) (state *TransferPayoutState, err error) {
+	state, err = p.store.Load(payoutNo)
+	return
+}
+
+// 拆分、提交并跟踪所有批次直到处理完成，相同的付款单号从上次中断的位置继续
+func (p *TransferPayout) Run(ctx context.Context, req TransferPayoutRequest) (state *TransferPayoutState, err error) {
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	batches := req.batches()
+	state, err = p.store.Load(req.PayoutNo)
+	if err != nil {
+		return
+	}
+	if state == nil {
+		state = &TransferPayoutState{PayoutNo: req.PayoutNo, Status: TransferPayoutRunning, CreatedAt: time.Now()}
+		for i, details := range batches {
+			b := TransferPayoutBatch{
+				OutBatchNo: fmt.Sprintf("%s%0*d", req.PayoutNo, transferPayoutSeqWidth, i+1),
+				TotalNum:   uint(len(details)),
+			}
+			for _, d := range details {
+				b.TotalAmount += d.TransferAmount
+			}
+			state.Batches = append(state.Batches, b)
+		}
+		err = p.save(state)
+		if err != nil {
+			return
+		}
+	} else if !state.matches(batches) {
+		err = ErrTransferPayoutChanged
+		return
+	}
+	if state.Status == TransferPayoutFinished {
+		return
+	}
+
+	for i := range state.Batches {
+		b := &state.Batches[i]
+		if b.BatchStatus != "" {
+			continue
+		}
+		err = p.submit(ctx, req, b, batches[i])
+		if saveErr := p.save(state); saveErr != nil && err == nil {
+			err = saveErr
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	err = p.track(ctx, state)
+	if err != nil {
+		return
+	}
+	err = p.collect(ctx, state, batches)
+	if err != nil {
+		return
+	}
+	state.Status = TransferPayoutFinished
+	err = p.save(state)
+	return
+}
+
+// 已保存的批次与本次的明细是否一致
+func (s *TransferPayoutState) matches(batches [][]TransferDetail) bool {
+	if len(s.Batches) != len(batches) {
+		return false
+	}
+	for i, details := range batches {
+		var amount uint
+		for _, d := range details {
+			amount += d.TransferAmount
+		}
+		if s.Batches[i].TotalNum != uint(len(details)) || s.Batches[i].TotalAmount != amount {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *TransferPayout) save(state *TransferPayoutState) error {
+	state.UpdatedAt = time.Now()
+	return p.store.Save(*state)
+}
+
+// 提交一个批次，可以重试的错误按间隔重试
+// 不可重试的错误或重试次数用完时把批次标记为被拒绝，只有ctx取消时返回错误
+func (p *TransferPayout) submit(ctx context.Context, req TransferPayoutRequest, b *TransferPayoutBatch, details []TransferDetail) (err error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultTransferPayoutMaxAttempts
+	}
+	interval := p.RetryInterval
+	if interval <= 0 {
+		interval = defaultTransferPayoutRetryInterval
+	}
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			case <-time.After(interval << uint(attempt-1)):
+			}
+		}
+		var resp *BatchTransferResponse
+		resp, err = p.transfers.BatchTransfer(ctx, BatchTransferRequest{
+			AppID:              req.AppID,
+			OutBatchNo:         b.OutBatchNo,
+			BatchName:          req.BatchName,
+			BatchRemark:        req.BatchRemark,
+			TotalAmount:        b.TotalAmount,
+			TotalNum:           b.TotalNum,
+			TransferDetailList: details,
+		})
+		b.Attempts++
+		b.UpdatedAt = time.Now()
+		if e, ok := err.(errIdempotent); ok && e.IsIdempotent() {
+			// 批次已经提交过，查询时获取微信批次单号
+			b.BatchStatus = TransferBatchStatusAccepted
+			b.Error = ""
+			err = nil
+			return
+		}
+		if err == nil {
+			b.BatchID = resp.BatchID
+			b.BatchStatus = TransferBatchStatusAccepted
+			b.Error = ""
+			return
+		}
+		b.Error = err.Error()
+		if !isRetryableError(err) {
+			break
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+		return
+	}
+	// 重试次数用完时结果不确定，批次可能已经创建
+	if isRetryableError(err) {
+		resp, queryErr := p.transfers.BatchTransferQueryByOutNo(ctx, BatchTransferQueryByOutNoRequest{OutBatchNo: b.OutBatchNo})
+		if queryErr == nil {
+			b.BatchID = resp.TransferBatch.BatchID
+			b.BatchStatus = resp.TransferBatch.BatchStatus
+			b.CloseReason = resp.TransferBatch.CloseReason
+			b.Error = ""
+			err = nil
+			return
+		}
+	}
+	b.BatchStatus = TransferPayoutBatchRejected
+	if e, ok := err.(*ErrBody); ok {
+		b.CloseReason = e.Code
+	} else {
+		b.CloseReason = err.Error()
+	}
+	err = nil
+	return
+}
+
+// 轮询未完成的批次直到全部处理完成
+func (p *TransferPayout) track(ctx context.Context, state *TransferPayoutState) (err error) {
+	interval := p.PollInterval
+	if interval <= 0 {
+		interval = defaultTransferPayoutPollInterval
+	}
+	for {
+		pending := false
+		for i := range state.Batches {
+			b := &state.Batches[i]
+			if b.Done() {
+				continue
+			}
+			resp, queryErr := p.transfers.BatchTransferQueryByOutNo(ctx, BatchTransferQueryByOutNoRequest{OutBatchNo: b.OutBatchNo})
+			b.UpdatedAt = time.Now()
+			if queryErr != nil {
+				b.Error = queryErr.Error()
+			} else {
+				b.BatchID = resp.TransferBatch.BatchID
+				b.BatchStatus = resp.TransferBatch.BatchStatus
+				b.CloseReason = resp.TransferBatch.CloseReason
+				b.Error = ""
+			}
+			pending = pending || !b.Done()
+		}
+		err = p.save(state)
+		if err != nil || !pending {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// 查询所有批次中成功和失败的明细，已关闭和被拒绝批次中的明细都按失败处理
+func (p *TransferPayout) collect(ctx context.Context, state *TransferPayoutState, batches [][]TransferDetail) (err error) {
+	var succeeded, failed []TransferPayoutDetail
+	for i, b := range state.Batches {
+		amounts := make(map[string]uint, len(batches[i]))
+		for _, d := range batches[i] {
+			amounts[d.OutDetailNo] = d.TransferAmount
+		}
+		if b.BatchStatus == TransferBatchStatusClosed || b.BatchStatus == TransferPayoutBatchRejected {
+			for _, d := range batches[i] {
+				failed = append(failed, TransferPayoutDetail{
+					OutBatchNo:  b.OutBatchNo,
+					OutDetailNo: d.OutDetailNo,
+					Amount:      d.TransferAmount,
+					Status:      TransferDetailStatusFail,
+					FailReason:  b.CloseReason,
+				})
+			}
+			continue
+		}
+		for _, status := range []string{TransferDetailStatusSuccess, TransferDetailStatusFail} {
+			var items []TransferDetailItem
+			items, err = NewTransferDetailIterator(p.transfers, b.OutBatchNo, status).All(ctx)
+			if err != nil {
+				return
+			}
+			for _, item := range items {
+				d := TransferPayoutDetail{
+					OutBatchNo:  b.OutBatchNo,
+					OutDetailNo: item.OutDetailNo,
+					DetailID:    item.DetailID,
+					Amount:      amounts[item.OutDetailNo],
+					Status:      item.Status,
+				}
+				if status == TransferDetailStatusSuccess {
+					succeeded = append(succeeded, d)
+				} else {
+					failed = append(failed, d)
+				}
+			}
+		}
+	}
+	state.Succeeded, state.Failed = succeeded, failed
+	return
+}
@@ -0,0 +1,375 @@
+package wxmch_api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+/*
+	转账电子回单
+	批次回单和明细回单都需要先申请，生成后返回下载地址和回单文件的摘要，下载时按摘要校验。
+	批次处理完成后才能申请回单，明细回单只能申请转账成功的明细
+*/
+
+const defaultTransferReceiptPollInterval = 3 * time.Second
+
+// 回单受理类型
+// 批量转账到零钱
+const TransferReceiptAcceptTypeBatch = "BATCH_TRANSFER"
+
+// 企业付款至零钱
+const TransferReceiptAcceptTypePocket = "TRANSFER_TO_POCKET"
+
+// 企业付款至银行卡
+const TransferReceiptAcceptTypeUser = "TRANSFER_TO_USER"
+
+// 回单状态
+// 已受理
+const TransferReceiptStateAccepted = "ACCEPTED"
+
+// 生成中
+const TransferReceiptStateGenerating = "GENERATING"
+
+// 已生成
+const TransferReceiptStateFinished = "FINISHED"
+
+// 生成失败，只有明细回单会失败
+const TransferReceiptStateFailed = "FAILED"
+
+// 转账批次电子回单
+type TransferBillReceipt struct {
+	// 商家批次单号
+	OutBatchNo string `json:"out_batch_no"`
+	// 电子回单申请单号
+	SignatureNo string `json:"signature_no"`
+	// 电子回单状态，ACCEPTED、FINISHED
+	SignatureStatus string `json:"signature_status"`
+	// 电子回单文件的摘要算法
+	HashType string `json:"hash_type"`
+	// 电子回单文件的摘要值
+	HashValue string `json:"hash_value"`
+	// 电子回单下载地址
+	DownloadUrl string `json:"download_url"`
+	// 创建时间
+	CreateTime string `json:"create_time"`
+	// 更新时间
+	UpdateTime string `json:"update_time"`
+}
+
+// 下载回单的参数
+func (r TransferBillReceipt) DownloadInfo() BillDownloadInfo {
+	return BillDownloadInfo{HashType: r.HashType, HashValue: r.HashValue, DownloadUrl: r.DownloadUrl}
+}
+
+type TransferBillReceiptApplyRequest struct {
+	// 商家批次单号
+	OutBatchNo string `json:"out_batch_no" validate:"required,min=5,max=32"`
+}
+
+// 转账账单电子回单申请受理API
+func (c MerchantApiClient) TransferBillReceiptApply(ctx context.Context, req TransferBillReceiptApplyRequest) (resp *TransferBillReceipt, err error) {
+	url := "/v3/transfer/bill-receipt"
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	body, _ := json.Marshal(&req)
+	res, err := c.doRequestAndVerifySignature(ctx, "POST", url, nil, body)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	return
+}
+
+type TransferBillReceiptQueryRequest struct {
+	// 商家批次单号
+	OutBatchNo string `json:"out_batch_no" validate:"required,min=5,max=32"`
+}
+
+// 查询转账账单电子回单API
+func (c MerchantApiClient) TransferBillReceiptQuery(ctx context.Context, req TransferBillReceiptQueryRequest) (resp *TransferBillReceipt, err error) {
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	url := fmt.Sprintf("/v3/transfer/bill-receipt/%s", req.OutBatchNo)
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", url, nil, nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	return
+}
+
+// 转账明细电子回单
+type TransferDetailReceipt struct {
+	// 受理类型
+	AcceptType string `json:"accept_type"`
+	// 商家批次单号
+	OutBatchNo string `json:"out_batch_no"`
+	// 商家明细单号
+	OutDetailNo string `json:"out_detail_no"`
+	// 电子回单受理单号
+	SignatureNo string `json:"signature_no"`
+	// 电子回单状态，ACCEPTED、GENERATING、FINISHED、FAILED
+	State string `json:"state"`
+	// 电子回单文件的摘要算法
+	HashType string `json:"hash_type"`
+	// 电子回单文件的摘要值
+	HashValue string `json:"hash_value"`
+	// 电子回单下载地址
+	DownloadUrl string `json:"download_url"`
+	// 失败原因
+	FailReason string `json:"fail_reason"`
+}
+
+// 下载回单的参数
+func (r TransferDetailReceipt) DownloadInfo() BillDownloadInfo {
+	return BillDownloadInfo{HashType: r.HashType, HashValue: r.HashValue, DownloadUrl: r.DownloadUrl}
+}
+
+type TransferDetailReceiptRequest struct {
+	// 受理类型，为空时为BATCH_TRANSFER
+	AcceptType string `json:"accept_type"`
+	// 商家批次单号，受理类型为BATCH_TRANSFER时必填
+	OutBatchNo string `json:"out_batch_no,omitempty" validate:"max=32"`
+	// 商家明细单号
+	OutDetailNo string `json:"out_detail_no" validate:"required,min=5,max=32"`
+}
+
+func (r TransferDetailReceiptRequest) validate() (errs ValidationErrors) {
+	if r.AcceptType == TransferReceiptAcceptTypeBatch || r.AcceptType == "" {
+		requireString(&errs, "/out_batch_no", r.OutBatchNo)
+	}
+	return
+}
+
+func (r *TransferDetailReceiptRequest) setDefaults() {
+	if r.AcceptType == "" {
+		r.AcceptType = TransferReceiptAcceptTypeBatch
+	}
+}
+
+// 转账明细电子回单受理API
+func (c MerchantApiClient) TransferDetailReceiptApply(ctx context.Context, req TransferDetailReceiptRequest) (resp *TransferDetailReceipt, err error) {
+	url := "/v3/transfer-detail/electronic-receipts"
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	req.setDefaults()
+	body, _ := json.Marshal(&req)
+	res, err := c.doRequestAndVerifySignature(ctx, "POST", url, nil, body)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	return
+}
+
+// 查询转账明细电子回单受理结果API
+func (c MerchantApiClient) TransferDetailReceiptQuery(ctx context.Context, req TransferDetailReceiptRequest) (resp *TransferDetailReceipt, err error) {
+	url := "/v3/transfer-detail/electronic-receipts"
+	err = Validate(req)
+	if err != nil {
+		return
+	}
+	req.setDefaults()
+	qm := map[string]string{
+		"accept_type":   req.AcceptType,
+		"out_detail_no": req.OutDetailNo,
+	}
+	if req.OutBatchNo != "" {
+		qm["out_batch_no"] = req.OutBatchNo
+	}
+	res, err := c.doRequestAndVerifySignature(ctx, "GET", url, qm, nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &resp)
+	return
+}
+
+// 一个明细回单的下载结果
+type TransferReceiptOutcome struct {
+	// 商家明细单号
+	OutDetailNo string
+	// 回单
+	Receipt *TransferDetailReceipt
+	// 申请、生成或下载失败的原因
+	Error error
+}
+
+// 批量下载明细回单的结果
+type TransferReceiptResult struct {
+	Outcomes []TransferReceiptOutcome
+}
+
+// 失败的明细回单
+func (r *TransferReceiptResult) Failed() (outcomes []TransferReceiptOutcome) {
+	for _, o := range r.Outcomes {
+		if o.Error != nil {
+			outcomes = append(outcomes, o)
+		}
+	}
+	return
+}
+
+// 申请、等待生成并下载电子回单
+type TransferReceipts struct {
+	transfers TransferService
+	bills     BillService
+	// 查询回单状态的间隔
+	PollInterval time.Duration
+}
+
+func NewTransferReceipts(transfers TransferService, bills BillService) *TransferReceipts {
+	return &TransferReceipts{transfers: transfers, bills: bills, PollInterval: defaultTransferReceiptPollInterval}
+}
+
+func (r *TransferReceipts) wait(ctx context.Context) error {
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = defaultTransferReceiptPollInterval
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(interval):
+		return nil
+	}
+}
+
+// 下载回单写入w，摘要不一致时返回ErrBillHashMismatch，已写入的内容应丢弃
+func (r *TransferReceipts) download(ctx context.Context, info BillDownloadInfo, w io.Writer) (err error) {
+	body, err := r.bills.DownloadBill(ctx, info)
+	if err != nil {
+		return
+	}
+	defer body.Close()
+	_, err = io.Copy(w, body)
+	return
+}
+
+// 申请批次回单并等待生成，已经申请过时查询已有的回单
+func (r *TransferReceipts) BillReceipt(ctx context.Context, outBatchNo string) (receipt *TransferBillReceipt, err error) {
+	receipt, err = r.transfers.TransferBillReceiptApply(ctx, TransferBillReceiptApplyRequest{OutBatchNo: outBatchNo})
+	if e, ok := err.(errIdempotent); ok && e.IsIdempotent() {
+		receipt, err = r.transfers.TransferBillReceiptQuery(ctx, TransferBillReceiptQueryRequest{OutBatchNo: outBatchNo})
+	}
+	for err == nil && receipt.SignatureStatus != TransferReceiptStateFinished {
+		err = r.wait(ctx)
+		if err != nil {
+			return
+		}
+		receipt, err = r.transfers.TransferBillReceiptQuery(ctx, TransferBillReceiptQueryRequest{OutBatchNo: outBatchNo})
+	}
+	return
+}
+
+// 申请、等待生成并下载批次回单（PDF）写入w
+func (r *TransferReceipts) DownloadBillReceipt(ctx context.Context, outBatchNo string, w io.Writer) (receipt *TransferBillReceipt, err error) {
+	receipt, err = r.BillReceipt(ctx, outBatchNo)
+	if err != nil {
+		return
+	}
+	err = r.download(ctx, receipt.DownloadInfo(), w)
+	return
+}
+
+// 申请明细回单，已经申请过时查询已有的回单
+func (r *TransferReceipts) applyDetail(ctx context.Context, req TransferDetailReceiptRequest) (receipt *TransferDetailReceipt, err error) {
+	receipt, err = r.transfers.TransferDetailReceiptApply(ctx, req)
+	if e, ok := err.(errIdempotent); ok && e.IsIdempotent() {
+		receipt, err = r.transfers.TransferDetailReceiptQuery(ctx, req)
+	}
+	return
+}
+
+// 等待明细回单生成
+func (r *TransferReceipts) waitDetail(ctx context.Context, req TransferDetailReceiptRequest, receipt *TransferDetailReceipt) (result *TransferDetailReceipt, err error) {
+	result = receipt
+	for result.State != TransferReceiptStateFinished {
+		if result.State == TransferReceiptStateFailed {
+			err = fmt.Errorf("明细%s的电子回单生成失败:%s", req.OutDetailNo, result.FailReason)
+			return
+		}
+		err = r.wait(ctx)
+		if err != nil {
+			return
+		}
+		var next *TransferDetailReceipt
+		next, err = r.transfers.TransferDetailReceiptQuery(ctx, req)
+		if err != nil {
+			return
+		}
+		result = next
+	}
+	return
+}
+
+// 申请、等待生成并下载明细回单（PDF）写入w
+func (r *TransferReceipts) DownloadDetailReceipt(ctx context.Context, req TransferDetailReceiptRequest, w io.Writer) (receipt *TransferDetailReceipt, err error) {
+	receipt, err = r.applyDetail(ctx, req)
+	if err != nil {
+		return
+	}
+	receipt, err = r.waitDetail(ctx, req, receipt)
+	if err != nil {
+		return
+	}
+	err = r.download(ctx, receipt.DownloadInfo(), w)
+	return
+}
+
+// 下载批次中所有转账成功明细的回单，先全部申请再逐个等待生成并下载，open返回写入回单的目标。
+// 单个明细失败不影响其他明细，只在查询明细列表失败时返回错误
+func (r *TransferReceipts) DownloadBatchDetailReceipts(ctx context.Context, outBatchNo string, open func(outDetailNo string) (io.WriteCloser, error)) (result *TransferReceiptResult, err error) {
+	items, err := NewTransferDetailIterator(r.transfers, outBatchNo, TransferDetailStatusSuccess).All(ctx)
+	if err != nil {
+		return
+	}
+	result = &TransferReceiptResult{}
+	for _, item := range items {
+		outcome := TransferReceiptOutcome{OutDetailNo: item.OutDetailNo}
+		outcome.Receipt, outcome.Error = r.applyDetail(ctx, TransferDetailReceiptRequest{
+			AcceptType:  TransferReceiptAcceptTypeBatch,
+			OutBatchNo:  outBatchNo,
+			OutDetailNo: item.OutDetailNo,
+		})
+		result.Outcomes = append(result.Outcomes, outcome)
+	}
+	for i := range result.Outcomes {
+		o := &result.Outcomes[i]
+		if o.Error != nil {
+			continue
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			o.Error = ctxErr
+			continue
+		}
+		req := TransferDetailReceiptRequest{AcceptType: TransferReceiptAcceptTypeBatch, OutBatchNo: outBatchNo, OutDetailNo: o.OutDetailNo}
+		o.Receipt, o.Error = r.waitDetail(ctx, req, o.Receipt)
+		if o.Error != nil {
+			continue
+		}
+		o.Error = r.save(ctx, o.Receipt, open)
+	}
+	return
+}
+
+func (r *TransferReceipts) save(ctx context.Context, receipt *TransferDetailReceipt, open func(outDetailNo string) (io.WriteCloser, error)) (err error) {
+	w, err := open(receipt.OutDetailNo)
+	if err != nil {
+		return
+	}
+	err = r.download(ctx, receipt.DownloadInfo(), w)
+	if closeErr := w.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return
+}
@@ -0,0 +1,160 @@
+package wxmch_api
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+	请求参数校验
+	在签名和发送之前按struct tag校验请求，tag格式：validate:"required,min=6,max=32"
+		required 不能为空（零值）
+		min/max 字符串按字节长度，数字按数值，切片按元素个数；值为空时不校验
+	跨字段的规则由请求实现validate()方法
+	错误字段使用JSON Pointer，与微信支付返回的ErrDetail.Field一致
+*/
+
+// 字段校验错误
+type FieldError struct {
+	// 错误参数的JSON Pointer
+	Field string `json:"field"`
+	// 错误的值
+	Value interface{} `json:"value"`
+	// 具体错误原因
+	Issue string `json:"issue"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s:%s", e.Field, e.Issue)
+}
+
+// 请求参数校验错误，包含所有不合法的字段
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	issues := make([]string, 0, len(e))
+	for _, fe := range e {
+		issues = append(issues, fe.Error())
+	}
+	return "参数错误:" + strings.Join(issues, "; ")
+}
+
+// 是否包含指定字段的错误
+func (e ValidationErrors) Has(field string) bool {
+	for _, fe := range e {
+		if fe.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *ValidationErrors) add(field string, value interface{}, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Value: value, Issue: fmt.Sprintf(format, args...)})
+}
+
+// 跨字段校验规则
+type validator interface {
+	validate() ValidationErrors
+}
+
+// 校验请求参数，全部合法时返回nil，否则返回ValidationErrors
+func Validate(req interface{}) error {
+	var errs ValidationErrors
+	validateValue(reflect.ValueOf(req), "", &errs)
+	if v, ok := req.(validator); ok {
+		errs = append(errs, v.validate()...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validateValue(v reflect.Value, path string, errs *ValidationErrors) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			validateValue(v.Elem(), path, errs)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := jsonFieldName(field)
+			if name == "-" {
+				continue
+			}
+			fieldPath := path + "/" + name
+			fv := v.Field(i)
+			if tag, ok := field.Tag.Lookup("validate"); ok {
+				validateField(fv, fieldPath, tag, errs)
+			}
+			validateValue(fv, fieldPath, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), path+"/"+strconv.Itoa(i), errs)
+		}
+	}
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+func validateField(v reflect.Value, path string, tag string, errs *ValidationErrors) {
+	isZero := v.IsZero()
+	for _, rule := range strings.Split(tag, ",") {
+		kv := strings.SplitN(rule, "=", 2)
+		switch kv[0] {
+		case "required":
+			if isZero {
+				errs.add(path, nil, "不能为空")
+				return
+			}
+		case "min", "max":
+			if isZero || len(kv) != 2 {
+				continue
+			}
+			limit, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			size, unit, ok := valueSize(v)
+			if !ok {
+				continue
+			}
+			if kv[0] == "min" && size < limit {
+				errs.add(path, v.Interface(), "%s不能小于%d", unit, limit)
+			}
+			if kv[0] == "max" && size > limit {
+				errs.add(path, v.Interface(), "%s不能大于%d", unit, limit)
+			}
+		}
+	}
+}
+
+// 用于min/max比较的大小
+func valueSize(v reflect.Value) (size int64, unit string, ok bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(len(v.String())), "长度(字节)", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return int64(v.Len()), "数量", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), "值", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), "值", true
+	}
+	return
+}